@@ -0,0 +1,238 @@
+package stable
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// Admin is the handler of the admin API, it should be served by a different
+// listener than the Server, every request requires the Token as a bearer token.
+type Admin struct {
+	Server *Server
+	Token  string
+
+	r *mux.Router
+}
+
+func NewAdmin(s *Server, token string) *Admin {
+	a := &Admin{Server: s, Token: token}
+	a.buildRouter()
+
+	return a
+}
+
+func (a *Admin) buildRouter() {
+	a.r = mux.NewRouter()
+	a.r.HandleFunc("/retractions", a.doListRetractions).Methods("GET")
+	a.r.HandleFunc("/retractions", a.doAddRetraction).Methods("POST")
+	a.r.HandleFunc("/retractions", a.doRemoveRetraction).Methods("DELETE")
+	a.r.HandleFunc("/pins", a.doListPins).Methods("GET")
+	a.r.HandleFunc("/pins", a.doAddPin).Methods("POST")
+	a.r.HandleFunc("/pins", a.doRemovePin).Methods("DELETE")
+	a.r.HandleFunc("/repositories", a.doListRepositories).Methods("GET")
+	a.r.HandleFunc("/cache", a.doPurgeCache).Methods("DELETE")
+	a.r.HandleFunc("/refresh", a.doRefresh).Methods("POST")
+	a.r.HandleFunc("/config", a.doDumpConfig).Methods("GET")
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.isAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="go-stable-admin"`)
+		a.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	a.r.ServeHTTP(w, r)
+}
+
+// isAuthorized returns true if the request has the bearer token, with an
+// empty Token all the requests are rejected.
+func (a *Admin) isAuthorized(r *http.Request) bool {
+	if a.Token == "" {
+		return false
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+func (a *Admin) doListRetractions(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.Server.Retractions.List())
+}
+
+func (a *Admin) doAddRetraction(w http.ResponseWriter, r *http.Request) {
+	retraction := &Retraction{}
+	if err := json.NewDecoder(r.Body).Decode(retraction); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Server.Retractions.Add(retraction); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a.writeJSON(w, http.StatusCreated, retraction)
+}
+
+func (a *Admin) doRemoveRetraction(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !a.Server.Retractions.Remove(q.Get("repository"), q.Get("tag")) {
+		a.writeError(w, http.StatusNotFound, "retraction not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) doListPins(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.Server.Pins.List())
+}
+
+func (a *Admin) doAddPin(w http.ResponseWriter, r *http.Request) {
+	pin := &Pin{}
+	if err := json.NewDecoder(r.Body).Decode(pin); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Server.Pins.Add(pin); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a.writeJSON(w, http.StatusCreated, pin)
+}
+
+func (a *Admin) doRemovePin(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !a.Server.Pins.Remove(q.Get("repository"), q.Get("constraint")) {
+		a.writeError(w, http.StatusNotFound, "pin not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RepositoryResponse is a repository with cached versions, as is returned by
+// the admin API.
+type RepositoryResponse struct {
+	Repository string                        `json:"repository"`
+	Majors     map[string]*ReferenceResponse `json:"majors"`
+}
+
+// RefreshRequest is the request to refresh the versions and packfile of a
+// package, by name, eg.: foo.bar/org/repository.v1
+type RefreshRequest struct {
+	Package string `json:"package"`
+}
+
+func (a *Admin) doListRepositories(w http.ResponseWriter, r *http.Request) {
+	res := make([]*RepositoryResponse, 0)
+	for _, repository := range a.Server.Cache.Repositories() {
+		if repo := a.buildRepositoryResponse(repository); repo != nil {
+			res = append(res, repo)
+		}
+	}
+
+	a.writeJSON(w, http.StatusOK, res)
+}
+
+// buildRepositoryResponse returns the resolved majors of the cached versions
+// of the given repository, with the exclusion policies applied.
+func (a *Admin) buildRepositoryResponse(repository string) *RepositoryResponse {
+	v, ok := a.Server.Cache.Lookup(repository)
+	if !ok {
+		return nil
+	}
+
+	pkg := &Package{Name: repository}
+	pkg.Repository, _ = transport.NewEndpoint("https://" + repository)
+
+	// only the cached results of the exclusion policies are used, the upstream
+	// is never called
+	v = a.Server.excludeVersions(pkg, v)
+
+	res := &RepositoryResponse{
+		Repository: repository,
+		Majors:     make(map[string]*ReferenceResponse, 0),
+	}
+
+	for major, ref := range v.Mayor() {
+		res.Majors[major] = newReferenceResponse(v, ref)
+	}
+
+	return res
+}
+
+// doPurgeCache removes the cached versions of the given repository, or all of
+// them if no repository is given.
+func (a *Admin) doPurgeCache(w http.ResponseWriter, r *http.Request) {
+	repository := r.URL.Query().Get("repository")
+	if repository == "" {
+		a.Server.Cache.Purge()
+	} else if !a.Server.Cache.Invalidate(repository) {
+		a.writeError(w, http.StatusNotFound, "repository not cached")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) doRefresh(w http.ResponseWriter, r *http.Request) {
+	req := &RefreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pkg, err := a.Server.Package(req.Package)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p := a.Server.Prefetcher
+	if p == nil {
+		p = NewPrefetcher(a.Server, 0, 0, 1)
+	}
+
+	repository := pkg.RepositoryName()
+	a.Server.Cache.Invalidate(repository)
+	if err := p.refresh(r.Context(), req.Package); err != nil {
+		a.writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	res := a.buildRepositoryResponse(repository)
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, res)
+}
+
+func (a *Admin) doDumpConfig(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.Server.Config())
+}
+
+func (a *Admin) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *Admin) writeError(w http.ResponseWriter, status int, msg string) {
+	a.writeJSON(w, status, map[string]string{"error": msg})
+}
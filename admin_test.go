@@ -0,0 +1,190 @@
+package stable
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type AdminSuite struct{}
+
+var _ = Suite(&AdminSuite{})
+
+func (s *AdminSuite) do(c *C, a *Admin, method, url, body string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, url, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+func (s *AdminSuite) TestUnauthorized(c *C) {
+	a := NewAdmin(NewDefaultServer("foo.bar"), "secret")
+
+	r, _ := http.NewRequest("GET", "http://localhost/retractions", nil)
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+
+	a = NewAdmin(NewDefaultServer("foo.bar"), "")
+	w = s.do(c, a, "GET", "http://localhost/retractions", "")
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+}
+
+func (s *AdminSuite) TestRetractions(c *C) {
+	server := NewDefaultServer("foo.bar")
+	a := NewAdmin(server, "secret")
+
+	w := s.do(c, a, "POST", "http://localhost/retractions", `{"repository":"github.com/org/repository","tag":"v1.0.0","reason":"broken"}`)
+	c.Assert(w.Code, Equals, http.StatusCreated)
+	c.Assert(server.Retractions.List(), HasLen, 1)
+
+	w = s.do(c, a, "POST", "http://localhost/retractions", `{"repository":"github.com/org/repository"}`)
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+
+	w = s.do(c, a, "GET", "http://localhost/retractions", "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, `[{"repository":"github.com/org/repository","tag":"v1.0.0","reason":"broken"}]`+"\n")
+
+	w = s.do(c, a, "DELETE", "http://localhost/retractions?repository=github.com/org/repository&tag=v1.0.0", "")
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(server.Retractions.List(), HasLen, 0)
+
+	w = s.do(c, a, "DELETE", "http://localhost/retractions?repository=github.com/org/repository&tag=v1.0.0", "")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+}
+
+func (s *AdminSuite) newCachedServer() *Server {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.0.0", plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")))
+
+	server := NewDefaultServer("foo.bar")
+	server.Cache = NewVersionsCache(time.Minute)
+	server.Cache.Set("github.com/org/repository", "foo", NewVersions(refs, nil), time.Now())
+	return server
+}
+
+func (s *AdminSuite) TestRepositories(c *C) {
+	server := s.newCachedServer()
+	server.Retractions.Add(&Retraction{Repository: "github.com/org/repository", Tag: "v2.0.0", Reason: "broken"})
+	a := NewAdmin(server, "secret")
+
+	w := s.do(c, a, "GET", "http://localhost/repositories", "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	var res []*RepositoryResponse
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &res), IsNil)
+	c.Assert(res, HasLen, 1)
+	c.Assert(res[0].Repository, Equals, "github.com/org/repository")
+	c.Assert(res[0].Majors, HasLen, 1)
+	c.Assert(res[0].Majors["v1"].Name, Equals, "refs/tags/v1.0.0")
+}
+
+// TestRepositoriesCachedExclusions checks that only the cached results of the
+// exclusion policies are used, without calling the upstream.
+func (s *AdminSuite) TestRepositoriesCachedExclusions(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.0.0", plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")))
+	peeled := map[string]plumbing.Hash{
+		"refs/tags/v1.0.0": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		"refs/tags/v2.0.0": plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	}
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Errorf("unexpected upstream call: %s", r.URL)
+	}))
+	defer upstream.Close()
+
+	server := NewDefaultServer("foo.bar")
+	server.Client = upstream.Client()
+	server.Cache = NewVersionsCache(time.Minute)
+	server.Cache.Set(upstream.Listener.Addr().String()+"/org/repository", "foo", NewVersions(refs, peeled), time.Now())
+	server.Signatures = NewSignatures()
+	server.Signatures.cache[signedTag{"v2.0.0", plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")}] = ""
+	a := NewAdmin(server, "secret")
+
+	w := s.do(c, a, "GET", "http://localhost/repositories", "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	var res []*RepositoryResponse
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &res), IsNil)
+	c.Assert(res, HasLen, 1)
+	c.Assert(res[0].Majors, HasLen, 1)
+	c.Assert(res[0].Majors["v2"].Name, Equals, "refs/tags/v2.0.0")
+}
+
+func (s *AdminSuite) TestPurgeCache(c *C) {
+	server := s.newCachedServer()
+	a := NewAdmin(server, "secret")
+
+	w := s.do(c, a, "DELETE", "http://localhost/cache?repository=github.com/org/other", "")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+
+	w = s.do(c, a, "DELETE", "http://localhost/cache?repository=github.com/org/repository", "")
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(server.Cache.Repositories(), HasLen, 0)
+
+	server = s.newCachedServer()
+	a = NewAdmin(server, "secret")
+
+	w = s.do(c, a, "DELETE", "http://localhost/cache", "")
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(server.Cache.Repositories(), HasLen, 0)
+}
+
+func (s *AdminSuite) TestRefreshInvalidPackage(c *C) {
+	a := NewAdmin(NewDefaultServer("foo.bar"), "secret")
+
+	w := s.do(c, a, "POST", "http://localhost/refresh", `{"package":"foo.bar/org"}`)
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *AdminSuite) TestConfig(c *C) {
+	server := NewDefaultServer("foo.bar")
+	server.Retractions.Add(&Retraction{Repository: "github.com/org/repository", Tag: "v1.0.0", Reason: "broken"})
+	server.Pins.Add(&Pin{Repository: "github.com/org/repository", Constraint: "v2", Tag: "v2.4.1"})
+	server.Prefetcher = NewPrefetcher(server, 0, 0, 1)
+	server.Prefetcher.Warmup = []string{"foo.bar/org/repository.v1"}
+	a := NewAdmin(server, "secret")
+
+	w := s.do(c, a, "GET", "http://localhost/config", "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, ""+
+		`{"retractions":[{"repository":"github.com/org/repository","tag":"v1.0.0","reason":"broken"}],`+
+		`"pins":[{"repository":"github.com/org/repository","constraint":"v2","tag":"v2.4.1"}],`+
+		`"channels":[{"name":"beta","rule":"prerelease"},{"name":"latest","rule":"branch"},{"name":"stable","rule":"release"}],`+
+		`"warmup":["foo.bar/org/repository.v1"]}`+"\n",
+	)
+}
+
+func (s *AdminSuite) TestPins(c *C) {
+	server := NewDefaultServer("foo.bar")
+	a := NewAdmin(server, "secret")
+
+	w := s.do(c, a, "POST", "http://localhost/pins", `{"repository":"github.com/org/repository","constraint":"v2","tag":"v2.4.1","expires":"2030-01-01T00:00:00Z"}`)
+	c.Assert(w.Code, Equals, http.StatusCreated)
+	c.Assert(server.Pins.Get("github.com/org/repository", "v2").Tag, Equals, "v2.4.1")
+
+	w = s.do(c, a, "POST", "http://localhost/pins", `{"repository":"github.com/org/repository","constraint":"v2"}`)
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+
+	w = s.do(c, a, "GET", "http://localhost/pins", "")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, `[{"repository":"github.com/org/repository","constraint":"v2","tag":"v2.4.1","expires":"2030-01-01T00:00:00Z"}]`+"\n")
+
+	w = s.do(c, a, "DELETE", "http://localhost/pins?repository=github.com/org/repository&constraint=v2", "")
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(server.Pins.List(), HasLen, 0)
+
+	w = s.do(c, a, "DELETE", "http://localhost/pins?repository=github.com/org/repository&constraint=v2", "")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+}
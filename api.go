@@ -0,0 +1,86 @@
+package stable
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// VersionsResponse is the response of the JSON versions API.
+type VersionsResponse struct {
+	Package    string                        `json:"package"`
+	Repository string                        `json:"repository"`
+	Constraint string                        `json:"constraint"`
+	Resolved   *ReferenceResponse            `json:"resolved"`
+	Pinned     *Pin                          `json:"pinned,omitempty"`
+	Majors     map[string]*ReferenceResponse `json:"majors"`
+	Tags       []*ReferenceResponse          `json:"tags"`
+}
+
+// ReferenceResponse is a git reference as is returned by the JSON API.
+type ReferenceResponse struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Commit string `json:"commit"`
+	Stable bool   `json:"stable"`
+	// Excluded is the reason why this version is never resolved, if any.
+	Excluded string `json:"excluded,omitempty"`
+}
+
+func newReferenceResponse(v *Versions, ref *plumbing.Reference) *ReferenceResponse {
+	if ref == nil {
+		return nil
+	}
+
+	return &ReferenceResponse{
+		Name:     ref.Name().String(),
+		Hash:     ref.Hash().String(),
+		Commit:   v.Peel(ref).Hash().String(),
+		Stable:   v.IsStable(ref),
+		Excluded: v.Excluded[ref.Name().Short()],
+	}
+}
+
+func (s *Server) doVersionsResponse(w http.ResponseWriter, r *http.Request) {
+	pkg := s.buildPackage(r)
+	fetcher := s.newFetcher(r, pkg)
+	versions, err := s.getVersions(r, fetcher, pkg)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	resolved, pin := s.resolve(pkg, versions)
+	res := &VersionsResponse{
+		Package:    pkg.Name,
+		Repository: pkg.Repository.String(),
+		Constraint: pkg.Constrain,
+		Resolved:   newReferenceResponse(versions, resolved),
+		Pinned:     pin,
+		Majors:     make(map[string]*ReferenceResponse, 0),
+		Tags:       make([]*ReferenceResponse, 0),
+	}
+
+	for major, ref := range versions.Mayor() {
+		res.Majors[major] = newReferenceResponse(versions, ref)
+	}
+
+	for _, ref := range versions.References {
+		if ref.IsTag() {
+			res.Tags = append(res.Tags, newReferenceResponse(versions, ref))
+		}
+	}
+
+	sort.Sort(byReferenceName(res.Tags))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type byReferenceName []*ReferenceResponse
+
+func (s byReferenceName) Len() int           { return len(s) }
+func (s byReferenceName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byReferenceName) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -0,0 +1,45 @@
+package stable
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+type APISuite struct{}
+
+var _ = Suite(&APISuite{})
+
+func (s *APISuite) TestDoVersionsResponse(c *C) {
+	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/releases.v1/@versions.json", nil)
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	response := w.Result()
+	c.Assert(response.StatusCode, Equals, http.StatusOK)
+	c.Assert(response.Header.Get("Content-Type"), Equals, "application/json")
+
+	var res VersionsResponse
+	c.Assert(json.NewDecoder(response.Body).Decode(&res), IsNil)
+	c.Assert(res.Package, Equals, "foo.bar/git-fixtures/releases.v1")
+	c.Assert(res.Constraint, Equals, "v1")
+	c.Assert(res.Resolved.Hash, Equals, "96f2c336f6aec28963719fb42513b88dfd709d09")
+	c.Assert(res.Majors["v1"].Hash, Equals, res.Resolved.Hash)
+	c.Assert(len(res.Tags) > 0, Equals, true)
+}
+
+func (s *APISuite) TestDoVersionsResponsePrivate(c *C) {
+	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/private.v1/@versions.json", nil)
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+}
@@ -0,0 +1,243 @@
+package stable
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// AuditEntry is a record of the audit log, one per upload-pack response.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Client is the HMAC of the credentials of the client keyed with the Key of
+	// the AuditLog, empty if anonymous.
+	Client     string `json:"client,omitempty"`
+	RemoteAddr string `json:"remote_addr"`
+	Package    string `json:"package"`
+	Repository string `json:"repository"`
+	Constraint string `json:"constraint"`
+	Ref        string `json:"ref"`
+	Hash       string `json:"hash"`
+	// Bytes is the size of the packfile sent to the client.
+	Bytes int64  `json:"bytes"`
+	Error string `json:"error,omitempty"`
+}
+
+// AuditLog is an append-only log of the resolutions served, as JSON lines. The
+// file is rotated when it reaches MaxSize, keeping MaxBackups old files with
+// the suffixes .1, .2, etc.
+type AuditLog struct {
+	Filename   string
+	MaxSize    int64
+	MaxBackups int
+	// Key is the secret of the HMAC identifying the clients, it must be the
+	// same across restarts, so the clients can be correlated.
+	Key []byte
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewAuditLog returns an AuditLog writing to filename, a zero maxSize disables
+// the rotation. If key is empty, the key is read from filename with the .key
+// suffix, generated the first time.
+func NewAuditLog(filename string, key []byte, maxSize int64, maxBackups int) (*AuditLog, error) {
+	l := &AuditLog{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		Key:        key,
+	}
+
+	if len(l.Key) == 0 {
+		var err error
+		l.Key, err = loadAuditKey(filename + ".key")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// loadAuditKey reads the hex encoded key from filename, if the file doesn't
+// exist a random key is generated and written to it.
+func loadAuditKey(filename string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("invalid audit key at %s", filename)
+		}
+
+		return key, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return key, f.Close()
+}
+
+// clientKey returns the HMAC-SHA256 of the basic auth credentials, empty if
+// the request is anonymous.
+func (l *AuditLog) clientKey(r *http.Request) string {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, l.Key)
+	mac.Write([]byte(username + ":" + password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.Filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+// Record appends the given entry to the log, rotating the file if needed.
+func (l *AuditLog) Record(e *AuditEntry) error {
+	if l == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate renames the current file and the backups, removing the oldest one,
+// and opens a new file, l.mu should be held.
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	if l.MaxBackups <= 0 {
+		if err := os.Remove(l.Filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return l.open()
+	}
+
+	for i := l.MaxBackups - 1; i > 0; i-- {
+		err := os.Rename(l.backupName(i), l.backupName(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(l.Filename, l.backupName(1)); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *AuditLog) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", l.Filename, n)
+}
+
+// Close closes the current file.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// audit records in the AuditLog, if any, the packfile sent for the given
+// version, the errors writing the log are reported to the stderr.
+func (s *Server) audit(r *http.Request, pkg *Package, ref, peeled *plumbing.Reference, written int64, err error) {
+	if s.Audit == nil {
+		return
+	}
+
+	e := &AuditEntry{
+		Time:       time.Now().UTC(),
+		Client:     s.Audit.clientKey(r),
+		RemoteAddr: s.remoteAddr(r),
+		Package:    pkg.Name,
+		Repository: pkg.RepositoryName(),
+		Constraint: pkg.Constrain,
+		Ref:        ref.Name().String(),
+		Hash:       peeled.Hash().String(),
+		Bytes:      written,
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	if err := s.Audit.Record(e); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing audit log: %s\n", err)
+	}
+}
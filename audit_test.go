@@ -0,0 +1,127 @@
+package stable
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+type AuditSuite struct {
+	dir string
+}
+
+var _ = Suite(&AuditSuite{})
+
+func (s *AuditSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "go-stable-audit")
+	c.Assert(err, IsNil)
+}
+
+func (s *AuditSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *AuditSuite) readEntries(c *C, filename string) []*AuditEntry {
+	f, err := os.Open(filename)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &AuditEntry{}
+		c.Assert(json.Unmarshal(scanner.Bytes(), e), IsNil)
+		entries = append(entries, e)
+	}
+
+	c.Assert(scanner.Err(), IsNil)
+	return entries
+}
+
+func (s *AuditSuite) TestAudit(c *C) {
+	filename := filepath.Join(s.dir, "audit.log")
+	l, err := NewAuditLog(filename, nil, 0, 0)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	srv := NewServer("foo.bar", "localhost:8080")
+	srv.Audit = l
+
+	pkg := &Package{Name: "foo.bar/org/repository.v1", Constrain: "v1"}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	r, _ := http.NewRequest("POST", "/org/repository.v1/git-upload-pack", nil)
+	r.RemoteAddr = "10.0.0.1:4242"
+	r.SetBasicAuth("token", "x-oauth-basic")
+
+	tag := plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea"))
+	peeled := plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	srv.audit(r, pkg, tag, peeled, 42, nil)
+
+	entries := s.readEntries(c, filename)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Client, HasLen, 64)
+	c.Assert(entries[0].Client, Not(Equals), getCredentialsKey(r))
+	c.Assert(entries[0].Client, Equals, l.clientKey(r))
+	c.Assert(entries[0].RemoteAddr, Equals, "10.0.0.1")
+	c.Assert(entries[0].Repository, Equals, "github.com/org/repository")
+	c.Assert(entries[0].Constraint, Equals, "v1")
+	c.Assert(entries[0].Ref, Equals, "refs/tags/v1.0.0")
+	c.Assert(entries[0].Hash, Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(entries[0].Bytes, Equals, int64(42))
+	c.Assert(entries[0].Error, Equals, "")
+}
+
+func (s *AuditSuite) TestAuditKey(c *C) {
+	filename := filepath.Join(s.dir, "audit.log")
+	l, err := NewAuditLog(filename, nil, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(l.Key, HasLen, 32)
+	l.Close()
+
+	// the generated key is kept across restarts
+	restarted, err := NewAuditLog(filename, nil, 0, 0)
+	c.Assert(err, IsNil)
+	defer restarted.Close()
+	c.Assert(restarted.Key, DeepEquals, l.Key)
+
+	given, err := NewAuditLog(filename, []byte("secret"), 0, 0)
+	c.Assert(err, IsNil)
+	defer given.Close()
+	c.Assert(given.Key, DeepEquals, []byte("secret"))
+
+	info, err := os.Stat(filename + ".key")
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *AuditSuite) TestRecordRotate(c *C) {
+	filename := filepath.Join(s.dir, "audit.log")
+	l, err := NewAuditLog(filename, nil, 100, 2)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	for i := 0; i < 4; i++ {
+		c.Assert(l.Record(&AuditEntry{Ref: "refs/tags/v1.0.0", Bytes: int64(i)}), IsNil)
+	}
+
+	c.Assert(s.readEntries(c, filename)[0].Bytes, Equals, int64(3))
+	c.Assert(s.readEntries(c, filename+".1")[0].Bytes, Equals, int64(2))
+	c.Assert(s.readEntries(c, filename+".2")[0].Bytes, Equals, int64(1))
+
+	_, err = os.Stat(filename + ".3")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *AuditSuite) TestRecordNil(c *C) {
+	var l *AuditLog
+	c.Assert(l.Record(&AuditEntry{}), IsNil)
+}
@@ -0,0 +1,207 @@
+package stable
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// invalidatedRetention is how long the invalidation of a repository is kept,
+// it should be longer than any call to the upstream listing the references.
+const invalidatedRetention = time.Hour
+
+// VersionsCache caches the Versions advertised by the upstream, by repository
+// and credentials, during the TTL. The entries of a repository are invalidated
+// on demand, eg.: when a push is notified by a webhook. The repository names
+// are case insensitive.
+type VersionsCache struct {
+	TTL time.Duration
+
+	mu          sync.Mutex
+	entries     map[string]map[string]*versionsCacheEntry
+	invalidated map[string]time.Time
+	evicted     time.Time
+}
+
+type versionsCacheEntry struct {
+	versions *Versions
+	expires  time.Time
+}
+
+func NewVersionsCache(ttl time.Duration) *VersionsCache {
+	return &VersionsCache{
+		TTL:         ttl,
+		entries:     make(map[string]map[string]*versionsCacheEntry, 0),
+		invalidated: make(map[string]time.Time, 0),
+	}
+}
+
+// Get returns the cached Versions of the repository for the given credentials
+// key, if any and not expired.
+func (c *VersionsCache) Get(repository, credentials string) (*Versions, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	repository = normalizeRepositoryName(repository)
+	entry, ok := c.entries[repository][credentials]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expires) {
+		c.remove(repository, credentials)
+		return nil, false
+	}
+
+	return entry.versions, true
+}
+
+// Set caches the Versions of the repository, since is the time when the
+// Versions were requested to the upstream, if the repository was invalidated
+// after it, the Versions are discarded since they may be outdated.
+func (c *VersionsCache) Set(repository, credentials string, v *Versions, since time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.evict(now)
+
+	repository = normalizeRepositoryName(repository)
+	if since.Before(c.invalidated[repository]) {
+		return
+	}
+
+	if _, ok := c.entries[repository]; !ok {
+		c.entries[repository] = make(map[string]*versionsCacheEntry, 0)
+	}
+
+	c.entries[repository][credentials] = &versionsCacheEntry{
+		versions: v,
+		expires:  now.Add(c.TTL),
+	}
+}
+
+// evict removes the expired entries and the invalidations older than
+// invalidatedRetention, at most once per minute, c.mu should be held.
+func (c *VersionsCache) evict(now time.Time) {
+	if now.Sub(c.evicted) < time.Minute {
+		return
+	}
+
+	c.evicted = now
+	for repository, entries := range c.entries {
+		for credentials, entry := range entries {
+			if now.After(entry.expires) {
+				c.remove(repository, credentials)
+			}
+		}
+	}
+
+	for repository, when := range c.invalidated {
+		if now.Sub(when) > invalidatedRetention {
+			delete(c.invalidated, repository)
+		}
+	}
+}
+
+// remove removes an entry, and the repository if it has no more entries, c.mu
+// should be held.
+func (c *VersionsCache) remove(repository, credentials string) {
+	delete(c.entries[repository], credentials)
+	if len(c.entries[repository]) == 0 {
+		delete(c.entries, repository)
+	}
+}
+
+// Invalidate removes the cached Versions of the repository, for every
+// credentials, returns false if nothing was cached.
+func (c *VersionsCache) Invalidate(repository string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	repository = normalizeRepositoryName(repository)
+	c.invalidated[repository] = time.Now()
+	_, ok := c.entries[repository]
+	delete(c.entries, repository)
+
+	return ok
+}
+
+// Purge removes all the cached Versions.
+func (c *VersionsCache) Purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for repository := range c.entries {
+		c.invalidated[repository] = now
+	}
+
+	c.entries = make(map[string]map[string]*versionsCacheEntry, 0)
+}
+
+// Repositories returns the sorted names of the repositories with cached
+// Versions.
+func (c *VersionsCache) Repositories() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for repository := range c.entries {
+		names = append(names, repository)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the most recently cached Versions of the repository, for any
+// credentials, even if expired but not evicted yet.
+func (c *VersionsCache) Lookup(repository string) (*Versions, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var latest *versionsCacheEntry
+	for _, entry := range c.entries[normalizeRepositoryName(repository)] {
+		if latest == nil || entry.expires.After(latest.expires) {
+			latest = entry
+		}
+	}
+
+	if latest == nil {
+		return nil, false
+	}
+
+	return latest.versions, true
+}
+
+// normalizeRepositoryName returns the name of the repository in lower case,
+// the port of the host, if any, is kept.
+func normalizeRepositoryName(repository string) string {
+	return strings.ToLower(repository)
+}
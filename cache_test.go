@@ -0,0 +1,101 @@
+package stable
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type CacheSuite struct{}
+
+var _ = Suite(&CacheSuite{})
+
+func (s *CacheSuite) TestGetSet(c *C) {
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+
+	cache := NewVersionsCache(time.Minute)
+	cache.Set("github.com/org/repository", "foo", v, time.Now())
+
+	cached, ok := cache.Get("github.com/org/repository", "foo")
+	c.Assert(ok, Equals, true)
+	c.Assert(cached, Equals, v)
+
+	_, ok = cache.Get("github.com/org/repository", "bar")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *CacheSuite) TestGetExpired(c *C) {
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+
+	cache := NewVersionsCache(-time.Second)
+	cache.Set("github.com/org/repository", "foo", v, time.Now())
+
+	_, ok := cache.Get("github.com/org/repository", "foo")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *CacheSuite) TestInvalidate(c *C) {
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+
+	cache := NewVersionsCache(time.Minute)
+	since := time.Now()
+	cache.Set("github.com/org/repository", "foo", v, since)
+	cache.Set("github.com/org/repository", "bar", v, since)
+
+	c.Assert(cache.Invalidate("github.com/org/repository"), Equals, true)
+	c.Assert(cache.Invalidate("github.com/org/repository"), Equals, false)
+
+	_, ok := cache.Get("github.com/org/repository", "bar")
+	c.Assert(ok, Equals, false)
+
+	// requested before the invalidation, may be outdated
+	cache.Set("github.com/org/repository", "foo", v, since)
+	_, ok = cache.Get("github.com/org/repository", "foo")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *CacheSuite) TestNil(c *C) {
+	var cache *VersionsCache
+	cache.Set("github.com/org/repository", "foo", nil, time.Now())
+
+	_, ok := cache.Get("github.com/org/repository", "foo")
+	c.Assert(ok, Equals, false)
+	c.Assert(cache.Invalidate("github.com/org/repository"), Equals, false)
+}
+
+func (s *CacheSuite) TestCaseInsensitive(c *C) {
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+
+	cache := NewVersionsCache(time.Minute)
+	cache.Set("github.com/Org/Repository", "foo", v, time.Now())
+
+	cached, ok := cache.Get("github.com/org/repository", "foo")
+	c.Assert(ok, Equals, true)
+	c.Assert(cached, Equals, v)
+
+	c.Assert(cache.Invalidate("GitHub.com/org/repository"), Equals, true)
+}
+
+func (s *CacheSuite) TestEvict(c *C) {
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+
+	cache := NewVersionsCache(-time.Second)
+	cache.Set("github.com/org/repository", "foo", v, time.Now())
+	cache.Set("github.com/org/repository", "bar", v, time.Now())
+	c.Assert(cache.entries["github.com/org/repository"], HasLen, 2)
+
+	_, ok := cache.Get("github.com/org/repository", "foo")
+	c.Assert(ok, Equals, false)
+	c.Assert(cache.entries["github.com/org/repository"], HasLen, 1)
+
+	cache.invalidated["gitlab.com/org/old"] = time.Now().Add(-2 * invalidatedRetention)
+	cache.invalidated["gitlab.com/org/new"] = time.Now()
+	cache.evicted = time.Time{}
+	cache.Set("gitlab.com/org/repository", "foo", v, time.Now())
+
+	c.Assert(cache.Repositories(), DeepEquals, []string{"gitlab.com/org/repository"})
+	c.Assert(cache.invalidated, HasLen, 1)
+	_, ok = cache.invalidated["gitlab.com/org/new"]
+	c.Assert(ok, Equals, true)
+}
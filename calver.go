@@ -0,0 +1,165 @@
+package stable
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// SchemeSemVer resolves the versions as semantic versions, the default.
+	SchemeSemVer = "semver"
+	// SchemeCalVer resolves the versions as calendar versions, eg.: 2024.05.1,
+	// the majors are the years, eg.: repository.v2024
+	SchemeCalVer = "calver"
+)
+
+// calVersion is a calendar version, such as 2024.05.1 or v2024.5.0-rc1, the
+// first part is the year.
+type calVersion struct {
+	parts      []int
+	prerelease string
+}
+
+// parseCalVer parses a calendar version, the year is required to have four
+// digits, the "v" prefix is optional.
+func parseCalVer(s string) (*calVersion, bool) {
+	s = strings.TrimPrefix(s, "v")
+
+	v := &calVersion{}
+	if i := strings.Index(s, "-"); i >= 0 {
+		s, v.prerelease = s[:i], s[i+1:]
+		if v.prerelease == "" {
+			return nil, false
+		}
+	}
+
+	for n, p := range strings.Split(s, ".") {
+		if p == "" || (n == 0 && len(p) != 4) {
+			return nil, false
+		}
+
+		i, err := strconv.Atoi(p)
+		if err != nil || i < 0 {
+			return nil, false
+		}
+
+		v.parts = append(v.parts, i)
+	}
+
+	return v, true
+}
+
+// Year returns the year of the version.
+func (v *calVersion) Year() int {
+	return v.parts[0]
+}
+
+// Compare returns -1, 0 or 1 if v is lower, equal or greater than o, the
+// missing parts are zero and a pre-release is lower than its release, see
+// comparePrerelease.
+func (v *calVersion) Compare(o *calVersion) int {
+	for i := 0; i < len(v.parts) || i < len(o.parts); i++ {
+		a, b := v.part(i), o.part(i)
+		if a != b {
+			if a < b {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	switch {
+	case v.prerelease == o.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case o.prerelease == "":
+		return -1
+	default:
+		return comparePrerelease(v.prerelease, o.prerelease)
+	}
+}
+
+// comparePrerelease compares the pre-releases by their dot separated
+// identifiers, the numeric suffixes are compared as numbers, eg.: rc2 is lower
+// than rc10 and beta.2 lower than beta.10
+func comparePrerelease(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if cmp := compareIdentifier(pa[i], pb[i]); cmp != 0 {
+			return cmp
+		}
+	}
+
+	switch {
+	case len(pa) < len(pb):
+		return -1
+	case len(pa) > len(pb):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareIdentifier(a, b string) int {
+	prefixA, numA, okA := splitNumericSuffix(a)
+	prefixB, numB, okB := splitNumericSuffix(b)
+	if okA && okB && prefixA == prefixB && numA != numB {
+		if numA < numB {
+			return -1
+		}
+
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+// splitNumericSuffix splits an identifier such as rc10 into rc and 10.
+func splitNumericSuffix(s string) (string, int, bool) {
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+
+	if i == len(s) {
+		return s, 0, false
+	}
+
+	n, err := strconv.Atoi(s[i:])
+	if err != nil {
+		return s, 0, false
+	}
+
+	return s[:i], n, true
+}
+
+func (v *calVersion) part(i int) int {
+	if i < len(v.parts) {
+		return v.parts[i]
+	}
+
+	return 0
+}
+
+// HasPrefix returns true if the parts of the prefix are the first parts of v,
+// eg.: 2024.05.1 has the prefixes 2024 and 2024.5 but not 2024.06
+func (v *calVersion) HasPrefix(prefix *calVersion) bool {
+	if len(prefix.parts) > len(v.parts) {
+		return false
+	}
+
+	for i, p := range prefix.parts {
+		if v.parts[i] != p {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsStable returns true if the version isn't a pre-release.
+func (v *calVersion) IsStable() bool {
+	return v.prerelease == ""
+}
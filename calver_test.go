@@ -0,0 +1,102 @@
+package stable
+
+import (
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type CalVerSuite struct{}
+
+var _ = Suite(&CalVerSuite{})
+
+func (s *CalVerSuite) TestParseCalVer(c *C) {
+	v, ok := parseCalVer("v2024.05.1-rc1")
+	c.Assert(ok, Equals, true)
+	c.Assert(v.parts, DeepEquals, []int{2024, 5, 1})
+	c.Assert(v.prerelease, Equals, "rc1")
+	c.Assert(v.Year(), Equals, 2024)
+	c.Assert(v.IsStable(), Equals, false)
+
+	for _, invalid := range []string{"v1.2.3", "24.05.1", "2024..1", "2024.05.1-", "master"} {
+		_, ok := parseCalVer(invalid)
+		c.Assert(ok, Equals, false, Commentf("%s", invalid))
+	}
+}
+
+func (s *CalVerSuite) TestCompare(c *C) {
+	compare := func(a, b string) int {
+		va, _ := parseCalVer(a)
+		vb, _ := parseCalVer(b)
+		return va.Compare(vb)
+	}
+
+	c.Assert(compare("2024.05.1", "2024.5.1"), Equals, 0)
+	c.Assert(compare("2024.05", "2024.05.0"), Equals, 0)
+	c.Assert(compare("2024.10.0", "2024.9.3"), Equals, 1)
+	c.Assert(compare("2023.12.9", "2024.01.0"), Equals, -1)
+	c.Assert(compare("2024.05.1-rc1", "2024.05.1"), Equals, -1)
+	c.Assert(compare("2024.05.1-rc1", "2024.05.1-rc2"), Equals, -1)
+	c.Assert(compare("2024.05.1-rc2", "2024.05.1-rc10"), Equals, -1)
+	c.Assert(compare("2024.05.1-beta.10", "2024.05.1-beta.2"), Equals, 1)
+	c.Assert(compare("2024.05.1-beta", "2024.05.1-beta.1"), Equals, -1)
+	c.Assert(compare("2024.05.1-beta9", "2024.05.1-rc1"), Equals, -1)
+}
+
+func (s *CalVerSuite) TestHasPrefix(c *C) {
+	v, _ := parseCalVer("2024.05.1")
+	for prefix, expected := range map[string]bool{
+		"v2024":       true,
+		"2024.5":      true,
+		"2024.05.1":   true,
+		"2024.06":     false,
+		"2023":        false,
+		"2024.05.1.0": false,
+	} {
+		p, _ := parseCalVer(prefix)
+		c.Assert(v.HasPrefix(p), Equals, expected, Commentf("%s", prefix))
+	}
+}
+
+func (s *CalVerSuite) TestVersions(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/heads/master", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/2023.12.2", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/2024.05.1", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/2024.10.0", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/2024.11.0-rc1", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("")))
+
+	config := &RepositoryConfig{Repository: "github.com/org/internal", Scheme: SchemeCalVer}
+	c.Assert(config.validate(), IsNil)
+
+	v := NewVersionsWithConfig(refs, nil, config)
+	c.Assert(v.BestMatch("v2024").Name().Short(), Equals, "2024.10.0")
+	c.Assert(v.BestMatch("v2024.11").Name().Short(), Equals, "2024.11.0-rc1")
+	c.Assert(v.HasStable("v2024.11"), Equals, false)
+	c.Assert(v.HasStable("v2024"), Equals, true)
+	c.Assert(v.BestMatch("v2024.5").Name().Short(), Equals, "2024.05.1")
+	c.Assert(v.BestMatch("v2023").Name().Short(), Equals, "2023.12.2")
+	c.Assert(v.BestMatch("v2022"), IsNil)
+	c.Assert(v.BestMatch("v1"), IsNil)
+	c.Assert(v.IsStable(v.References["2024.11.0-rc1"]), Equals, false)
+	c.Assert(v.IsStable(v.References["2024.10.0"]), Equals, true)
+
+	tags := v.Tags()
+	c.Assert(tags[0].Name().Short(), Equals, "2024.11.0-rc1")
+	c.Assert(tags[len(tags)-1].Name().Short(), Equals, "v1.0.0")
+
+	stable := v.Channel(&Channel{Name: "stable", Rule: ChannelRelease, Constraint: "v2024"})
+	c.Assert(stable.Name().Short(), Equals, "2024.10.0")
+
+	beta := v.Channel(&Channel{Name: "beta", Rule: ChannelPrerelease, Constraint: "v2024"})
+	c.Assert(beta.Name().Short(), Equals, "2024.11.0-rc1")
+
+	majors := v.Mayor()
+	c.Assert(majors, HasLen, 2)
+	c.Assert(majors["v2023"].Name().Short(), Equals, "2023.12.2")
+	c.Assert(majors["v2024"].Name().Short(), Equals, "2024.10.0")
+
+	excluded := v.Exclude(map[string]string{"2024.10.0": "retracted: broken"})
+	c.Assert(excluded.BestMatch("v2024").Name().Short(), Equals, "2024.05.1")
+}
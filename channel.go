@@ -0,0 +1,92 @@
+package stable
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidChannel           = errors.New("invalid channel, expected a lowercase name and a rule: release, prerelease or branch")
+	ErrInvalidChannelConstraint = errors.New("invalid channel constraint, expected a version, eg.: v2 or v2.1")
+)
+
+const (
+	// ChannelRelease selects the highest tag without a pre-release suffix.
+	ChannelRelease = "release"
+	// ChannelPrerelease selects the highest tag, including the pre-releases.
+	ChannelPrerelease = "prerelease"
+	// ChannelBranch selects the head of a branch, the default one if none is
+	// given.
+	ChannelBranch = "branch"
+)
+
+var (
+	channelNameRegexp  = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	versionNameRegexp  = regexp.MustCompile(`^v[0-9.]+$`)
+	constraintVarRegex = regexp.MustCompile(`\{` + ConstraintKey + `:[^}]+\}`)
+)
+
+// Channel is a named release channel, eg.: example.com/repository.stable,
+// resolved following its Rule instead of a version constraint.
+type Channel struct {
+	Name string `json:"name"`
+	// Rule is one of ChannelRelease, ChannelPrerelease or ChannelBranch.
+	Rule string `json:"rule"`
+	// Branch followed by the ChannelBranch rule, the default branch if empty.
+	Branch string `json:"branch,omitempty"`
+	// Constraint limits the tags considered by the ChannelRelease and the
+	// ChannelPrerelease rules, eg.: v2
+	Constraint string `json:"constraint,omitempty"`
+}
+
+// DefaultChannels are the channels available if none is configured.
+func DefaultChannels() map[string]*Channel {
+	return map[string]*Channel{
+		"stable": {Name: "stable", Rule: ChannelRelease},
+		"beta":   {Name: "beta", Rule: ChannelPrerelease},
+		"latest": {Name: "latest", Rule: ChannelBranch},
+	}
+}
+
+func (c *Channel) validate() error {
+	if !channelNameRegexp.MatchString(c.Name) || versionNameRegexp.MatchString(c.Name) {
+		return ErrInvalidChannel
+	}
+
+	if c.Constraint != "" && !versionNameRegexp.MatchString(c.Constraint) {
+		return ErrInvalidChannelConstraint
+	}
+
+	switch c.Rule {
+	case ChannelRelease, ChannelPrerelease, ChannelBranch:
+		return nil
+	default:
+		return ErrInvalidChannel
+	}
+}
+
+// channelRoute returns the BaseRoute with the constraint replaced by the names
+// of the channels, empty if there are no channels.
+func (s *Server) channelRoute() string {
+	if len(s.Channels) == 0 || !constraintVarRegex.MatchString(s.BaseRoute) {
+		return ""
+	}
+
+	var names []string
+	for name := range s.Channels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return constraintVarRegex.ReplaceAllLiteralString(
+		s.BaseRoute, "{"+ConstraintKey+":(?:"+strings.Join(names, "|")+")}",
+	)
+}
+
+type byChannel []*Channel
+
+func (s byChannel) Len() int           { return len(s) }
+func (s byChannel) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byChannel) Less(i, j int) bool { return s[i].Name < s[j].Name }
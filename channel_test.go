@@ -0,0 +1,79 @@
+package stable
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+type ChannelSuite struct{}
+
+var _ = Suite(&ChannelSuite{})
+
+func (s *ChannelSuite) TestChannelRoute(c *C) {
+	server := NewDefaultServer("foo.bar")
+	c.Assert(server.channelRoute(), Equals, "/{org:[a-z0-9-]+}/{repository:[a-z0-9-/]+}.{version:(?:beta|latest|stable)}")
+
+	server.Channels = nil
+	c.Assert(server.channelRoute(), Equals, "")
+}
+
+func (s *ChannelSuite) TestPackage(c *C) {
+	server := NewDefaultServer("foo.bar")
+
+	pkg, err := server.Package("foo.bar/org/repository.stable")
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Name, Equals, "foo.bar/org/repository.stable")
+	c.Assert(pkg.Constrain, Equals, "stable")
+	c.Assert(pkg.RepositoryName(), Equals, "github.com/org/repository")
+
+	_, err = server.Package("foo.bar/org/repository.unknown")
+	c.Assert(err, Equals, ErrPackageNotFound)
+}
+
+func (s *ChannelSuite) TestDoMetaImportResponse(c *C) {
+	r, _ := http.NewRequest("GET", "http://foo.bar/org/repository.beta/subpkg?go-get=1", nil)
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.Handler.ServeHTTP(w, r)
+
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Matches, `(?s).*content="foo.bar/org/repository.beta git https://foo.bar/org/repository.beta".*`)
+}
+
+func (s *ChannelSuite) TestConfig(c *C) {
+	server := NewDefaultServer("foo.bar")
+	config := &Config{Channels: []*Channel{
+		{Name: "lts", Rule: ChannelRelease, Constraint: "v1"},
+	}}
+
+	c.Assert(config.Apply(server), IsNil)
+	c.Assert(server.Channels, HasLen, 1)
+
+	pkg, err := server.Package("foo.bar/org/repository.lts")
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Constrain, Equals, "lts")
+
+	_, err = server.Package("foo.bar/org/repository.stable")
+	c.Assert(err, Equals, ErrPackageNotFound)
+
+	config = &Config{Channels: []*Channel{{Name: "v2", Rule: ChannelRelease}}}
+	c.Assert(config.Apply(server), NotNil)
+
+	config = &Config{Channels: []*Channel{{Name: "nightly", Rule: "foo"}}}
+	c.Assert(config.Apply(server), NotNil)
+}
+
+func (s *ChannelSuite) TestValidate(c *C) {
+	c.Assert((&Channel{Name: "stable", Rule: ChannelRelease}).validate(), IsNil)
+	c.Assert((&Channel{Name: "v2", Rule: ChannelRelease, Constraint: "v2"}).validate(), Equals, ErrInvalidChannel)
+	c.Assert((&Channel{Name: "stable", Rule: "foo"}).validate(), Equals, ErrInvalidChannel)
+	c.Assert((&Channel{Name: "stable-v2", Rule: ChannelRelease, Constraint: "v2.1"}).validate(), IsNil)
+
+	for _, invalid := range []string{"v", "2", "v2-rc1", "master"} {
+		ch := &Channel{Name: "stable", Rule: ChannelRelease, Constraint: invalid}
+		c.Assert(ch.validate(), Equals, ErrInvalidChannelConstraint, Commentf("%s", invalid))
+	}
+}
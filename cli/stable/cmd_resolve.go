@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mcuadros/go-stable"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+type ResolveCommand struct {
+	RouteOptions
+
+	Username string `long:"username" description:"username or token for private repositories"`
+	Password string `long:"password" description:"password for private repositories"`
+	Config   string `long:"config" description:"JSON configuration file of the server, eg.: retractions, pins or channels"`
+	JSON     bool   `long:"json" description:"print the result as JSON"`
+
+	Args struct {
+		Package    string `positional-arg-name:"package" required:"yes" description:"go-stable URL (example.com/org/repository.v1) or repository (github.com/org/repository)"`
+		Constraint string `positional-arg-name:"constraint" description:"version constraint, required when a repository is given"`
+	} `positional-args:"yes"`
+}
+
+type resolveOutput struct {
+	Package    string            `json:"package"`
+	Repository string            `json:"repository"`
+	Constraint string            `json:"constraint"`
+	Pin        *stable.Pin       `json:"pin,omitempty"`
+	Resolved   *referenceOutput  `json:"resolved"`
+	Candidates []referenceOutput `json:"candidates"`
+}
+
+type referenceOutput struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Commit string `json:"commit"`
+}
+
+func newReferenceOutput(v *stable.Versions, ref *plumbing.Reference) referenceOutput {
+	return referenceOutput{
+		Name:   ref.Name().String(),
+		Hash:   ref.Hash().String(),
+		Commit: v.Peel(ref).Hash().String(),
+	}
+}
+
+// Execute resolves the package as the server does, with the same config.
+func (c *ResolveCommand) Execute(args []string) error {
+	s, pkg, err := c.buildPackage()
+	if err != nil {
+		return err
+	}
+
+	var auth transport.AuthMethod
+	if c.Username != "" {
+		auth = githttp.NewBasicAuth(c.Username, c.Password)
+	}
+
+	versions, err := s.Versions(context.Background(), pkg, auth)
+	if err != nil {
+		return err
+	}
+
+	output := &resolveOutput{
+		Package:    pkg.Name,
+		Repository: pkg.Repository.String(),
+		Constraint: pkg.Constrain,
+		Candidates: make([]referenceOutput, 0),
+	}
+
+	ref, pin := s.Resolve(pkg, versions)
+	output.Pin = pin
+	if ref != nil {
+		resolved := newReferenceOutput(versions, ref)
+		output.Resolved = &resolved
+	}
+
+	for _, ref := range versions.Match(pkg.Constrain) {
+		output.Candidates = append(output.Candidates, newReferenceOutput(versions, ref))
+	}
+
+	if c.JSON {
+		return json.NewEncoder(os.Stdout).Encode(output)
+	}
+
+	c.print(output)
+	return nil
+}
+
+// buildPackage returns the server, configured as with --config, and the
+// package to be resolved.
+func (c *ResolveCommand) buildPackage() (*stable.Server, *stable.Package, error) {
+	host := c.Args.Package
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+
+	s := c.newServer(strings.SplitN(host, "/", 2)[0])
+	if err := loadConfig(s, c.Config); err != nil {
+		return nil, nil, err
+	}
+
+	if c.Args.Constraint == "" {
+		pkg, err := s.Package(c.Args.Package)
+		return s, pkg, err
+	}
+
+	e, err := transport.NewEndpoint("https://" + c.Args.Package)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkg := &stable.Package{
+		Name:       c.Args.Package,
+		Repository: e,
+		Constrain:  c.Args.Constraint,
+	}
+
+	pkg.Config = s.Repositories.Match(pkg.RepositoryName())
+	return s, pkg, nil
+}
+
+func (c *ResolveCommand) print(o *resolveOutput) {
+	fmt.Printf("package:    %s\n", o.Package)
+	fmt.Printf("repository: %s\n", o.Repository)
+	fmt.Printf("constraint: %s\n", o.Constraint)
+	if o.Pin != nil {
+		fmt.Printf("pinned:     %s %s\n", o.Pin.Tag, o.Pin.Reason)
+	}
+
+	if o.Resolved == nil {
+		fmt.Printf("resolved:   none\n")
+	} else {
+		fmt.Printf("resolved:   %s %s (commit %s)\n", o.Resolved.Name, o.Resolved.Hash, o.Resolved.Commit)
+	}
+
+	fmt.Printf("candidates:\n")
+	for _, ref := range o.Candidates {
+		fmt.Printf("  %s %s\n", ref.Name, ref.Hash)
+	}
+}
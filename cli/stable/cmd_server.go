@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/dkumor/acmewrapper"
 	"github.com/mcuadros/go-stable"
 	"github.com/urfave/negroni"
+	"golang.org/x/time/rate"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 )
 
 const (
@@ -19,17 +25,90 @@ const (
 	BaseRouteSrvOrg = "/{srv:[a-z0-9-.]+}/{org:[a-z0-9-]+}/{repository:[a-z0-9-/]+}.{version:v[0-9.]+}"
 )
 
-type ServerCommand struct {
-	Host         string `long:"host" description:"host of the server"`
+type RouteOptions struct {
 	Server       string `long:"server" default:"github.com" description:"repository git server"`
 	Organization string `long:"organization" description:"repository organization"`
 	Repository   string `long:"repository" default:"github.com" description:"repository name"`
 	BaseRoute    string `long:"base-route" description:"base gorilla/mux route"`
+}
+
+func (o *RouteOptions) getBaseRoute() string {
+	if o.BaseRoute != "" {
+		return o.BaseRoute
+	}
+
+	if o.Server == "" {
+		return BaseRouteSrvOrg
+	}
+
+	if o.Organization == "" {
+		return BaseRouteOrg
+	}
+
+	return BaseRoute
+}
+
+// newServer returns a stable.Server for the given host configured with the
+// route options.
+func (o *RouteOptions) newServer(host string) *stable.Server {
+	s := stable.NewServer(o.getBaseRoute(), host)
+	s.Default.Server = o.Server
+	s.Default.Organization = o.Organization
+	s.Default.Repository = o.Repository
+
+	return s
+}
+
+type ServerCommand struct {
+	RouteOptions
 
+	Host         string `long:"host" description:"host of the server"`
 	Addr         string `long:"addr" default:":443" description:"http server addr"`
 	RedirectAddr string `long:"redirect-addr" description:"http to https redirect server addr"`
 	CertFolder   string `long:"certs" default:"/certificates" description:"TLS certificate folder"`
 
+	ClientRate    float64 `long:"client-rate" description:"max requests per second per client, 0 means unlimited"`
+	ClientBurst   int     `long:"client-burst" default:"10" description:"max burst of requests per client"`
+	UpstreamRate  float64 `long:"upstream-rate" description:"max calls per second to each git server, 0 means unlimited"`
+	UpstreamBurst int     `long:"upstream-burst" default:"20" description:"max burst of calls to each git server"`
+
+	TrustedProxies []string `long:"trusted-proxy" description:"IP address or CIDR range of a proxy allowed to set the client address with X-Real-IP, can be repeated"`
+
+	ConnectTimeout time.Duration `long:"connect-timeout" default:"10s" description:"timeout connecting to the git servers"`
+	ReadTimeout    time.Duration `long:"read-timeout" default:"60s" description:"timeout waiting for data from the git servers"`
+	MaxRequestSize int64         `long:"max-request-size" default:"10485760" description:"max size in bytes of a decoded upload-pack request"`
+
+	CacheTTL         time.Duration `long:"cache-ttl" description:"time the versions of a repository are cached, disabled if zero"`
+	GitHubHookSecret string        `long:"github-hook-secret" env:"STABLE_GITHUB_HOOK_SECRET" description:"secret of the GitHub webhooks served at /hooks/github"`
+	GitLabHookSecret string        `long:"gitlab-hook-secret" env:"STABLE_GITLAB_HOOK_SECRET" description:"secret token of the GitLab webhooks served at /hooks/gitlab"`
+	GiteaHookSecret  string        `long:"gitea-hook-secret" env:"STABLE_GITEA_HOOK_SECRET" description:"secret of the Gitea webhooks served at /hooks/gitea"`
+
+	PackCache           string        `long:"pack-cache" description:"directory caching the packfiles fetched from the git servers, disabled if empty"`
+	PackCacheMaxSize    int64         `long:"pack-cache-max-size" default:"10737418240" description:"max size in bytes of the pack cache, the least recently used packfiles are removed, unlimited if zero"`
+	PrefetchInterval    time.Duration `long:"prefetch-interval" description:"interval refreshing the most requested packages, disabled if zero"`
+	PrefetchTop         int           `long:"prefetch-top" default:"50" description:"number of most requested packages refreshed every interval"`
+	PrefetchConcurrency int           `long:"prefetch-concurrency" default:"4" description:"max packages refreshed at the same time"`
+	PrefetchToken       string        `long:"prefetch-token" env:"STABLE_PREFETCH_TOKEN" description:"token used to refresh private repositories"`
+
+	Config       string `long:"config" description:"JSON configuration file, eg.: retractions"`
+	GoModRetract bool   `long:"go-mod-retract" description:"exclude the versions retracted at the go.mod files"`
+	CheckSubpkg  bool   `long:"check-subpackages" description:"answer 404 to the go get of subpackages not existing at the resolved version"`
+	VerifyImport bool   `long:"verify-imports" description:"answer 404 to the go get of packages not resolving to any version"`
+	AdminAddr    string `long:"admin-addr" description:"admin API server addr, disabled if empty"`
+	AdminToken   string `long:"admin-token" env:"STABLE_ADMIN_TOKEN" description:"bearer token required by the admin API"`
+
+	PGPKeyring        string `long:"pgp-keyring" description:"armored OpenPGP keyring, only the tags signed by these keys are resolved"`
+	SSHAllowedSigners string `long:"ssh-allowed-signers" description:"SSH allowed signers file, only the tags signed by these keys are resolved"`
+
+	AuditLog        string `long:"audit-log" description:"file of the JSON-lines audit log of the packfiles served, disabled if empty"`
+	AuditMaxSize    int64  `long:"audit-max-size" default:"104857600" description:"max size in bytes of the audit log before being rotated"`
+	AuditMaxBackups int    `long:"audit-max-backups" default:"10" description:"number of rotated audit logs kept"`
+	AuditKey        string `long:"audit-key" env:"STABLE_AUDIT_KEY" description:"secret of the HMAC identifying the clients at the audit log, if empty a random key is generated and kept next to the log, at --audit-log with the .key suffix"`
+
+	SumDBKey   string `long:"sumdb-key" description:"file with the private key signing the checksum database, disabled if empty"`
+	SumDBLog   string `long:"sumdb-log" default:"/certificates/sumdb.log" description:"file storing the checksum database log"`
+	SumDBToken string `long:"sumdb-token" env:"STABLE_SUMDB_TOKEN" description:"token used by the checksum database to read private repositories"`
+
 	LogLevel  string `long:"log-level" default:"info" description:"log level, values: debug, info, warn or panic"`
 	LogFormat string `long:"log-format" default:"text" description:"log format, values: text or json"`
 
@@ -55,33 +134,132 @@ func (c *ServerCommand) buildServer() error {
 		return fmt.Errorf("missing host name, please set `--host`")
 	}
 
-	if c.BaseRoute == "" {
-		c.BaseRoute = c.getBaseRoute()
+	c.s = c.newServer(c.Host)
+	c.s.Addr = c.Addr
+	c.s.Limiter = stable.NewRateLimiter(
+		rate.Limit(c.ClientRate), c.ClientBurst,
+		rate.Limit(c.UpstreamRate), c.UpstreamBurst,
+	)
+
+	proxies, err := stable.ParseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return err
 	}
 
-	c.s = stable.NewServer(c.BaseRoute, c.Host)
-	c.s.Addr = c.Addr
-	c.s.Default.Server = c.Server
-	c.s.Default.Organization = c.Organization
-	c.s.Default.Repository = c.Repository
+	c.s.TrustedProxies = proxies
+
+	c.s.Client = stable.NewUpstreamClient(c.ConnectTimeout, c.ReadTimeout)
+	c.s.MaxRequestSize = c.MaxRequestSize
+	c.s.VerifyImports = c.VerifyImport
+	if c.CacheTTL > 0 {
+		c.s.Cache = stable.NewVersionsCache(c.CacheTTL)
+	}
+
+	c.s.Hooks = &stable.Hooks{
+		GitHub: c.GitHubHookSecret,
+		GitLab: c.GitLabHookSecret,
+		Gitea:  c.GiteaHookSecret,
+	}
+
+	if c.PackCache != "" {
+		var err error
+		c.s.Packs, err = stable.NewPackCache(c.PackCache, c.PackCacheMaxSize)
+		if err != nil {
+			return fmt.Errorf("error building pack cache: %s", err)
+		}
+	}
+
+	c.s.Prefetcher = stable.NewPrefetcher(c.s, c.PrefetchInterval, c.PrefetchTop, c.PrefetchConcurrency)
+	if c.PrefetchToken != "" {
+		c.s.Prefetcher.Auth = githttp.NewBasicAuth(c.PrefetchToken, "")
+	}
+
+	if c.GoModRetract {
+		c.s.GoModRetractions = stable.NewGoModRetractions()
+	}
+
+	if c.CheckSubpkg {
+		c.s.Subpackages = stable.NewSubpackages()
+	}
+
+	if err := c.buildSignatures(); err != nil {
+		return err
+	}
+
+	if err := c.buildSumDB(); err != nil {
+		return err
+	}
+
+	if c.AuditLog != "" {
+		var err error
+		c.s.Audit, err = stable.NewAuditLog(c.AuditLog, []byte(c.AuditKey), c.AuditMaxSize, c.AuditMaxBackups)
+		if err != nil {
+			return fmt.Errorf("error opening audit log: %s", err)
+		}
+	}
+
+	return c.loadConfig()
+}
+
+func (c *ServerCommand) buildSignatures() error {
+	if c.PGPKeyring == "" && c.SSHAllowedSigners == "" {
+		return nil
+	}
+
+	c.s.Signatures = stable.NewSignatures()
+	if c.PGPKeyring != "" {
+		if err := c.s.Signatures.LoadPGPKeyring(c.PGPKeyring); err != nil {
+			return err
+		}
+	}
+
+	if c.SSHAllowedSigners != "" {
+		if err := c.s.Signatures.LoadSSHAllowedSigners(c.SSHAllowedSigners); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (c *ServerCommand) getBaseRoute() string {
-	if c.BaseRoute != "" {
-		return c.BaseRoute
+func (c *ServerCommand) buildSumDB() error {
+	if c.SumDBKey == "" {
+		return nil
 	}
 
-	if c.Server == "" {
-		return BaseRouteSrvOrg
+	key, err := ioutil.ReadFile(c.SumDBKey)
+	if err != nil {
+		return err
 	}
 
-	if c.Organization == "" {
-		return BaseRouteOrg
+	c.s.SumDB, err = stable.NewSumDB(c.s, string(key), c.SumDBLog)
+	if err != nil {
+		return fmt.Errorf("error building checksum database: %s", err)
 	}
 
-	return BaseRoute
+	if c.SumDBToken != "" {
+		c.s.SumDB.Auth = githttp.NewBasicAuth(c.SumDBToken, "")
+	}
+
+	return nil
+}
+
+func (c *ServerCommand) loadConfig() error {
+	return loadConfig(c.s, c.Config)
+}
+
+// loadConfig applies the JSON configuration file to the given server, if any.
+func loadConfig(s *stable.Server, filename string) error {
+	if filename == "" {
+		return nil
+	}
+
+	config, err := stable.LoadConfig(filename)
+	if err != nil {
+		return fmt.Errorf("error loading config %q: %s", filename, err)
+	}
+
+	return config.Apply(s)
 }
 
 func (c *ServerCommand) buildMiddleware() error {
@@ -158,9 +336,27 @@ func (c *ServerCommand) listen() error {
 	}
 
 	go c.listenRedirectHTTP()
+	go c.listenAdmin()
+	go c.s.Prefetcher.Run(context.Background())
 	return c.s.Serve(listener)
 }
 
+func (c *ServerCommand) listenAdmin() {
+	if c.AdminAddr == "" {
+		return
+	}
+
+	if c.AdminToken == "" {
+		fmt.Fprintf(os.Stderr, "admin API disabled, missing `--admin-token`\n")
+		return
+	}
+
+	admin := stable.NewAdmin(c.s, c.AdminToken)
+	if err := http.ListenAndServe(c.AdminAddr, admin); err != nil {
+		fmt.Fprintf(os.Stderr, "error serving admin API: %s\n", err)
+	}
+}
+
 func (c *ServerCommand) getACME() (*acmewrapper.AcmeWrapper, error) {
 	return acmewrapper.New(acmewrapper.Config{
 		Domains:          []string{c.Host},
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/mod/sumdb/note"
+)
+
+type SumDBKeygenCommand struct {
+	Args struct {
+		Name string `positional-arg-name:"name" required:"yes" description:"name of the checksum database, usually the host of the server"`
+		File string `positional-arg-name:"file" required:"yes" description:"file to write the private key"`
+	} `positional-args:"yes"`
+}
+
+func (c *SumDBKeygenCommand) Execute(args []string) error {
+	skey, vkey, err := note.GenerateKey(rand.Reader, c.Args.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(c.Args.File, []byte(skey+"\n"), 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("private key written to %s, use it with `stable server --sumdb-key`\n", c.Args.File)
+	fmt.Printf("GOSUMDB=\"%s https://%s/sumdb\"\n", vkey, c.Args.Name)
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/mcuadros/go-stable"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+type VersionsCommand struct {
+	Username     string `long:"username" description:"username or token for private repositories"`
+	Password     string `long:"password" description:"password for private repositories"`
+	Organization string `long:"organization" description:"list all the repositories of a organization, eg.: github.com/mcuadros"`
+	Format       string `long:"format" default:"text" description:"output format, values: text, csv or json"`
+	Config       string `long:"config" description:"JSON configuration file of the server, eg.: retractions, pins or channels"`
+
+	Args struct {
+		Repositories []string `positional-arg-name:"repository" description:"repository, eg.: github.com/org/repository"`
+	} `positional-args:"yes"`
+}
+
+type majorOutput struct {
+	Repository string `json:"repository"`
+	Major      string `json:"major"`
+	Name       string `json:"name"`
+	Hash       string `json:"hash"`
+	// Stable is true if the major has any stable tag, even if the resolved
+	// reference is a branch or a pre-release.
+	Stable bool `json:"stable"`
+}
+
+func (c *VersionsCommand) Execute(args []string) error {
+	repositories, err := c.getRepositories()
+	if err != nil {
+		return err
+	}
+
+	if len(repositories) == 0 {
+		return fmt.Errorf("missing repositories, please provide a repository or `--organization`")
+	}
+
+	s := stable.NewServer(BaseRouteSrvOrg, "")
+	if err := loadConfig(s, c.Config); err != nil {
+		return err
+	}
+
+	var output []majorOutput
+	for _, repository := range repositories {
+		majors, err := c.getMajors(s, repository)
+		if err != nil {
+			return fmt.Errorf("error listing %s: %s", repository, err)
+		}
+
+		output = append(output, majors...)
+	}
+
+	switch c.Format {
+	case "text":
+		return c.printText(output)
+	case "csv":
+		return c.printCSV(output)
+	case "json":
+		return json.NewEncoder(os.Stdout).Encode(output)
+	default:
+		return fmt.Errorf("invalid format, %q", c.Format)
+	}
+}
+
+func (c *VersionsCommand) getRepositories() ([]string, error) {
+	repositories := c.Args.Repositories
+	if c.Organization == "" {
+		return repositories, nil
+	}
+
+	provider, err := NewProvider(c.Organization, c.Username, c.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	listed, err := provider.Repositories()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(repositories, listed...), nil
+}
+
+// getMajors returns the majors of the repository, excluding the versions as
+// the server does with the same config.
+func (c *VersionsCommand) getMajors(s *stable.Server, repository string) ([]majorOutput, error) {
+	pkg := &stable.Package{Name: repository}
+
+	var err error
+	pkg.Repository, err = transport.NewEndpoint("https://" + repository)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg.Config = s.Repositories.Match(pkg.RepositoryName())
+
+	var auth transport.AuthMethod
+	if c.Username != "" {
+		auth = githttp.NewBasicAuth(c.Username, c.Password)
+	}
+
+	versions, err := s.Versions(context.Background(), pkg, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []majorOutput
+	for major, ref := range versions.Mayor() {
+		output = append(output, majorOutput{
+			Repository: repository,
+			Major:      major,
+			Name:       ref.Name().String(),
+			Hash:       versions.Peel(ref).Hash().String(),
+			Stable:     versions.HasStable(major),
+		})
+	}
+
+	sort.Sort(byMajor(output))
+	return output, nil
+}
+
+func (c *VersionsCommand) printText(output []majorOutput) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tMAJOR\tREFERENCE\tHASH\tSTABLE")
+	for _, m := range output {
+		isStable := "yes"
+		if !m.Stable {
+			isStable = "no"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Repository, m.Major, m.Name, m.Hash, isStable)
+	}
+
+	return w.Flush()
+}
+
+func (c *VersionsCommand) printCSV(output []majorOutput) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"repository", "major", "reference", "hash", "stable"})
+	for _, m := range output {
+		w.Write([]string{m.Repository, m.Major, m.Name, m.Hash, strconv.FormatBool(m.Stable)})
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+type byMajor []majorOutput
+
+func (s byMajor) Len() int      { return len(s) }
+func (s byMajor) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byMajor) Less(i, j int) bool {
+	if s[i].Repository != s[j].Repository {
+		return s[i].Repository < s[j].Repository
+	}
+
+	a, _ := strconv.Atoi(s[i].Major[1:])
+	b, _ := strconv.Atoi(s[j].Major[1:])
+	return a < b
+}
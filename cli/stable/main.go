@@ -18,6 +18,9 @@ func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	parser := flags.NewParser(nil, flags.Default)
 	parser.AddCommand("server", "", "", &ServerCommand{})
+	parser.AddCommand("resolve", "preview the version resolution of a package", "", &ResolveCommand{})
+	parser.AddCommand("versions", "list the resolved majors of repositories", "", &VersionsCommand{})
+	parser.AddCommand("sumdb-keygen", "generate a key pair for the checksum database", "", &SumDBKeygenCommand{})
 
 	if _, err := parser.Parse(); err != nil {
 		if err, ok := err.(*flags.Error); ok {
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Provider lists the repositories of an organization from a git provider API.
+type Provider interface {
+	Repositories() ([]string, error)
+}
+
+// NewProvider returns the Provider for the given organization, in the format
+// <server>/<organization>, only github.com is supported.
+func NewProvider(organization, username, password string) (Provider, error) {
+	p := strings.SplitN(organization, "/", 2)
+	if len(p) != 2 || p[1] == "" {
+		return nil, fmt.Errorf("invalid organization %q, expected <server>/<organization>", organization)
+	}
+
+	switch p[0] {
+	case "github.com":
+		return &GitHubProvider{Organization: p[1], Username: username, Password: password}, nil
+	default:
+		return nil, fmt.Errorf("unsupported provider %q", p[0])
+	}
+}
+
+type GitHubProvider struct {
+	Organization string
+	Username     string
+	Password     string
+}
+
+// errNotFound is returned by list when the owner is not found.
+var errNotFound = errors.New("not found")
+
+// Repositories lists the repositories of the organization, if no organization
+// is found by the name, the repositories of the user are listed instead.
+func (p *GitHubProvider) Repositories() ([]string, error) {
+	repositories, err := p.listAll("orgs")
+	if err == errNotFound {
+		repositories, err = p.listAll("users")
+	}
+
+	return repositories, err
+}
+
+func (p *GitHubProvider) listAll(owner string) ([]string, error) {
+	var repositories []string
+	for page := 1; ; page++ {
+		names, err := p.list(owner, page)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(names) == 0 {
+			return repositories, nil
+		}
+
+		repositories = append(repositories, names...)
+	}
+}
+
+func (p *GitHubProvider) list(owner string, page int) ([]string, error) {
+	url := fmt.Sprintf("https://api.github.com/%s/%s/repos?per_page=100&page=%d", owner, p.Organization, page)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from github.com: %d", res.StatusCode)
+	}
+
+	var repositories []struct {
+		FullName string `json:"full_name"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&repositories); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, r := range repositories {
+		names = append(names, "github.com/"+r.FullName)
+	}
+
+	return names, nil
+}
@@ -2,6 +2,8 @@ package stable
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/mcuadros/go-version"
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -14,46 +16,139 @@ type Package struct {
 	Name       string
 	Repository transport.Endpoint
 	Constrain  string
-	Versions   Versions
+	Versions   *Versions
+	// Config is the config of the repository, if any.
+	Config *RepositoryConfig
+	// Subpackage is the path of the requested package inside the repository,
+	// if any, eg.: cmd/tool
+	Subpackage string
 }
 
-type Versions map[string]*plumbing.Reference
+// RepositoryName returns the name of the repository without scheme, eg.:
+// github.com/org/repository
+func (p *Package) RepositoryName() string {
+	return p.Repository.Host + strings.TrimSuffix(p.Repository.Path, ".git")
+}
+
+// Versions holds the tags and branches of a repository by short name, the
+// annotated tags are peeled to the commit they point to using Peeled.
+type Versions struct {
+	References map[string]*plumbing.Reference
+	Peeled     map[plumbing.ReferenceName]plumbing.Hash
+	// Excluded are the versions, by short name, that are never resolved with
+	// the reason of the exclusion, eg.: retracted by the operator.
+	Excluded map[string]string
+	// Config is the config of the repository, if any.
+	Config *RepositoryConfig
+	// Head is the short name of the branch pointed by the HEAD of the
+	// upstream, if advertised, eg.: main
+	Head string
+
+	// versions are the versions extracted from the tag names, by short name.
+	versions map[string]string
+}
+
+// NewVersions returns the Versions from the given references, peeled are the
+// peeled values (^{}) of the annotated tags from the advertisement, by name.
+func NewVersions(refs memory.ReferenceStorage, peeled map[string]plumbing.Hash) *Versions {
+	return NewVersionsWithConfig(refs, peeled, nil)
+}
+
+// NewVersionsWithConfig is like NewVersions, but the versions are extracted
+// from the tags names following the given config, the tags not matching the
+// TagPattern are ignored. When a branch and a tag share the same short name,
+// the tag is kept, or the branch if the scheme is SchemeGopkgIn.
+func NewVersionsWithConfig(refs memory.ReferenceStorage, peeled map[string]plumbing.Hash, c *RepositoryConfig) *Versions {
+	versions := &Versions{
+		References: make(map[string]*plumbing.Reference, 0),
+		Peeled:     make(map[plumbing.ReferenceName]plumbing.Hash, 0),
+		Excluded:   make(map[string]string, 0),
+		Config:     c,
+		versions:   make(map[string]string, 0),
+	}
 
-func NewVersions(refs memory.ReferenceStorage) Versions {
-	versions := make(Versions, 0)
 	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			versions.Head = ref.Target().Short()
+			continue
+		}
+
 		if !ref.IsTag() && !ref.IsBranch() {
 			continue
 		}
 
-		versions[ref.Name().Short()] = ref
+		name := ref.Name().Short()
+		if other, ok := versions.References[name]; ok && !c.precedes(ref, other) {
+			continue
+		}
+
+		delete(versions.versions, name)
+		if ref.IsTag() {
+			version, ok := c.Version(name)
+			if !ok {
+				continue
+			}
+
+			versions.versions[name] = version
+		}
+
+		versions.References[name] = ref
+	}
+
+	for name, hash := range peeled {
+		versions.Peeled[plumbing.ReferenceName(name)] = hash
 	}
 
 	return versions
 }
 
-func (v Versions) Match(needed string) []*plumbing.Reference {
-	c := newConstrain(needed)
+// Match returns the references matching the needed version, sorted from the
+// highest version. For calendar versions the pre-releases are only returned if
+// no stable version matches.
+func (v *Versions) Match(needed string) []*plumbing.Reference {
+	matched := v.match(needed)
+	if !v.Config.IsCalVer() {
+		return matched
+	}
 
-	var names []string
-	for _, ref := range v {
-		name := ref.Name().Short()
-		if c.Match(version.Normalize(name)) {
-			names = append(names, name)
+	var stable []*plumbing.Reference
+	for _, ref := range matched {
+		if v.IsStable(ref) {
+			stable = append(stable, ref)
 		}
 	}
 
-	version.Sort(names)
+	if len(stable) == 0 {
+		return matched
+	}
+
+	return stable
+}
+
+// match returns the references matching the needed version, including the
+// pre-releases, sorted from the highest version.
+func (v *Versions) match(needed string) []*plumbing.Reference {
+	var names []string
+	switch {
+	case v.Config.IsCalVer():
+		names = v.matchCalVer(needed)
+	case v.Config.IsGopkgIn():
+		names = v.matchGopkgIn(needed)
+	default:
+		names = v.matchSemVer(needed)
+	}
+
+	v.sort(names)
 	var matched []*plumbing.Reference
 	for n := len(names) - 1; n >= 0; n-- {
-		matched = append(matched, v[names[n]])
+		matched = append(matched, v.References[names[n]])
 	}
 
 	return matched
 }
 
-func (v Versions) BestMatch(needed string) *plumbing.Reference {
-	if version, ok := v[needed]; ok {
+func (v *Versions) BestMatch(needed string) *plumbing.Reference {
+	if version, ok := v.References[needed]; ok && !v.IsExcluded(needed) {
 		return version
 	}
 
@@ -69,11 +164,191 @@ func (v Versions) BestMatch(needed string) *plumbing.Reference {
 	return nil
 }
 
-func (v Versions) handleV0() *plumbing.Reference {
-	return v.BestMatch("master")
+func (v *Versions) matchSemVer(needed string) []string {
+	c := newConstrain(needed)
+
+	var names []string
+	for _, ref := range v.References {
+		name := ref.Name().Short()
+		if v.IsExcluded(name) {
+			continue
+		}
+
+		if c.Match(version.Normalize(v.Version(name))) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// matchCalVer returns the short names of the tags with the given calendar
+// version as prefix, eg.: v2024 matches 2024.05.1 and 2024.11.0
+func (v *Versions) matchCalVer(needed string) []string {
+	prefix, ok := parseCalVer(needed)
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	for name, ref := range v.References {
+		if !ref.IsTag() || v.IsExcluded(name) {
+			continue
+		}
+
+		if cv, ok := parseCalVer(v.Version(name)); ok && cv.HasPrefix(prefix) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Tags returns the tags not excluded, sorted from the highest version.
+func (v *Versions) Tags() []*plumbing.Reference {
+	var names []string
+	for name, ref := range v.References {
+		if ref.IsTag() && !v.IsExcluded(name) {
+			names = append(names, name)
+		}
+	}
+
+	v.sort(names)
+	var tags []*plumbing.Reference
+	for n := len(names) - 1; n >= 0; n-- {
+		tags = append(tags, v.References[names[n]])
+	}
+
+	return tags
+}
+
+// Version returns the version of the given short name, extracted from the tag
+// name if the repository has a TagPattern, the branches are returned as is.
+func (v *Versions) Version(name string) string {
+	if version, ok := v.versions[name]; ok {
+		return version
+	}
+
+	return name
+}
+
+// sort sorts the given short names by version, from the lowest.
+func (v *Versions) sort(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		cmp := v.compare(names[i], names[j])
+
+		if cmp == 0 {
+			return names[i] < names[j]
+		}
+
+		return cmp < 0
+	})
+}
+
+// compare compares the versions of the given short names, following the
+// scheme of the repository, the calendar versions not parsable are the lowest.
+func (v *Versions) compare(a, b string) int {
+	if !v.Config.IsCalVer() {
+		return version.CompareSimple(
+			version.Normalize(v.Version(a)),
+			version.Normalize(v.Version(b)),
+		)
+	}
+
+	ca, okA := parseCalVer(v.Version(a))
+	cb, okB := parseCalVer(v.Version(b))
+	switch {
+	case okA && okB:
+		return ca.Compare(cb)
+	case okA:
+		return 1
+	case okB:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// IsStable returns true if the reference is a tag whose version hasn't a
+// pre-release suffix, such as -rc1 or -beta.
+func (v *Versions) IsStable(ref *plumbing.Reference) bool {
+	if !ref.IsTag() {
+		return false
+	}
+
+	name := v.Version(ref.Name().Short())
+	if v.Config.IsCalVer() {
+		cv, ok := parseCalVer(name)
+		return ok && cv.IsStable()
+	}
+
+	return isStableVersion(name)
+}
+
+// HasStable returns true if any tag, not excluded, matching the needed version
+// is stable, eg.: a major with a release, whatever the resolved reference is.
+func (v *Versions) HasStable(needed string) bool {
+	for _, ref := range v.match(needed) {
+		if v.IsStable(ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Channel returns the reference selected by the rule of the given channel.
+func (v *Versions) Channel(c *Channel) *plumbing.Reference {
+	switch c.Rule {
+	case ChannelRelease, ChannelPrerelease:
+		candidates := v.Tags()
+		if c.Constraint != "" {
+			candidates = v.match(c.Constraint)
+		}
+
+		for _, ref := range candidates {
+			if ref.IsTag() && (c.Rule == ChannelPrerelease || v.IsStable(ref)) {
+				return ref
+			}
+		}
+	case ChannelBranch:
+		branch := c.Branch
+		if branch == "" {
+			branch = v.DefaultBranch()
+		}
+
+		if ref, ok := v.References[branch]; ok && ref.IsBranch() && !v.IsExcluded(branch) {
+			return ref
+		}
+	}
+
+	return nil
+}
+
+func (v *Versions) handleV0() *plumbing.Reference {
+	return v.BestMatch(v.DefaultBranch())
+}
+
+// DefaultBranch returns the short name of the default branch, the one of the
+// config of the repository, if any, otherwise the HEAD of the upstream, master
+// if unknown.
+func (v *Versions) DefaultBranch() string {
+	if v.Config != nil && v.Config.DefaultBranch != "" {
+		return v.Config.DefaultBranch
+	}
+
+	if v.Head != "" {
+		return v.Head
+	}
+
+	return "master"
 }
 
-func (v Versions) Mayor() map[string]*plumbing.Reference {
+func (v *Versions) Mayor() map[string]*plumbing.Reference {
+	if v.Config.IsCalVer() {
+		return v.years()
+	}
+
 	output := make(map[string]*plumbing.Reference, 0)
 	for i := 0; i < 100; i++ {
 		mayor := fmt.Sprintf("v%d", i)
@@ -85,8 +360,82 @@ func (v Versions) Mayor() map[string]*plumbing.Reference {
 	return output
 }
 
+// years is the Mayor equivalent for calendar versions, the highest version of
+// every year by "v" plus the year, eg.: v2024
+func (v *Versions) years() map[string]*plumbing.Reference {
+	output := make(map[string]*plumbing.Reference, 0)
+	for name, ref := range v.References {
+		if !ref.IsTag() || v.IsExcluded(name) {
+			continue
+		}
+
+		cv, ok := parseCalVer(v.Version(name))
+		if !ok {
+			continue
+		}
+
+		year := fmt.Sprintf("v%d", cv.Year())
+		if _, ok := output[year]; ok {
+			continue
+		}
+
+		if m := v.BestMatch(year); m != nil {
+			output[year] = m
+		}
+	}
+
+	return output
+}
+
+// Exclude returns a copy of the Versions where the given versions, by short
+// name, are excluded, the values of the map are the reasons.
+func (v *Versions) Exclude(excluded map[string]string) *Versions {
+	output := &Versions{
+		References: v.References,
+		Peeled:     v.Peeled,
+		Excluded:   make(map[string]string, len(v.Excluded)+len(excluded)),
+		Config:     v.Config,
+		Head:       v.Head,
+		versions:   v.versions,
+	}
+
+	for name, reason := range v.Excluded {
+		output.Excluded[name] = reason
+	}
+
+	for name, reason := range excluded {
+		if _, ok := v.References[name]; ok {
+			output.Excluded[name] = reason
+		}
+	}
+
+	return output
+}
+
+// IsExcluded returns true if the version with the given short name is excluded.
+func (v *Versions) IsExcluded(name string) bool {
+	_, ok := v.Excluded[name]
+	return ok
+}
+
+// Peel returns a reference with the same name as the given one, pointing to
+// the commit of the annotated tag, lightweight tags and branches are returned
+// as they are.
+func (v *Versions) Peel(ref *plumbing.Reference) *plumbing.Reference {
+	commit, ok := v.Peeled[ref.Name()]
+	if !ok {
+		return ref
+	}
+
+	return plumbing.NewHashReference(ref.Name(), commit)
+}
+
+func isStableVersion(name string) bool {
+	return !strings.Contains(version.Normalize(name), "-")
+}
+
 func newConstrain(needed string) *version.ConstraintGroup {
-	if needed[0] == 'v' && needed[1] >= 28 && needed[1] <= 57 {
+	if len(needed) > 1 && needed[0] == 'v' && needed[1] >= 28 && needed[1] <= 57 {
 		needed = needed[1:]
 	}
 
@@ -24,7 +24,7 @@ func (s *SuiteCommon) TestNewVersions(c *C) {
 	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.0.3", plumbing.NewHash("")))
 	refs.SetReference(plumbing.NewHashReference("refs/tags/v4.0.0-rc1", plumbing.NewHash("")))
 
-	v := NewVersions(refs)
+	v := NewVersions(refs, nil)
 	c.Assert(v.BestMatch("v0").Name().String(), Equals, "refs/heads/master")
 	c.Assert(v.BestMatch("v1.1").Name().String(), Equals, "refs/tags/1.1.3")
 	c.Assert(v.BestMatch("1.1").Name().String(), Equals, "refs/tags/1.1.3")
@@ -36,6 +36,67 @@ func (s *SuiteCommon) TestNewVersions(c *C) {
 
 	refs.SetReference(plumbing.NewHashReference("refs/tags/v0.0.0", plumbing.NewHash("")))
 
-	v = NewVersions(refs)
+	v = NewVersions(refs, nil)
 	c.Assert(v.BestMatch("v0").Name().String(), Equals, "refs/tags/v0.0.0")
 }
+
+func (s *SuiteCommon) TestNewVersionsAnnotatedTags(c *C) {
+	tag := plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	commit := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	lightweight := plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")
+
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", tag))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.0.0", lightweight))
+
+	v := NewVersions(refs, map[string]plumbing.Hash{"refs/tags/v1.0.0": commit})
+
+	annotated := v.BestMatch("v1")
+	c.Assert(annotated.Hash(), Equals, tag)
+	c.Assert(v.Peel(annotated).Name().String(), Equals, "refs/tags/v1.0.0")
+	c.Assert(v.Peel(annotated).Hash(), Equals, commit)
+
+	c.Assert(v.Peel(v.BestMatch("v2")).Hash(), Equals, lightweight)
+}
+
+func (s *SuiteCommon) TestChannel(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/heads/master", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/heads/develop", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.0.3", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v3.0.0-rc1", plumbing.NewHash("")))
+
+	v := NewVersions(refs, nil)
+	c.Assert(v.Channel(&Channel{Rule: ChannelRelease}).Name().String(), Equals, "refs/tags/v2.0.3")
+	c.Assert(v.Channel(&Channel{Rule: ChannelRelease, Constraint: "v1"}).Name().String(), Equals, "refs/tags/v1.0.0")
+	c.Assert(v.Channel(&Channel{Rule: ChannelPrerelease}).Name().String(), Equals, "refs/tags/v3.0.0-rc1")
+	c.Assert(v.Channel(&Channel{Rule: ChannelBranch}).Name().String(), Equals, "refs/heads/master")
+	c.Assert(v.Channel(&Channel{Rule: ChannelBranch, Branch: "develop"}).Name().String(), Equals, "refs/heads/develop")
+	c.Assert(v.Channel(&Channel{Rule: ChannelBranch, Branch: "v1.0.0"}), IsNil)
+
+	v = v.Exclude(map[string]string{"v3.0.0-rc1": "retracted: broken"})
+	c.Assert(v.Channel(&Channel{Rule: ChannelPrerelease}).Name().String(), Equals, "refs/tags/v2.0.3")
+}
+
+func (s *SuiteCommon) TestDefaultBranch(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"))
+	refs.SetReference(plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/heads/develop", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("")))
+
+	v := NewVersions(refs, nil)
+	c.Assert(v.Head, Equals, "main")
+	c.Assert(v.DefaultBranch(), Equals, "main")
+	c.Assert(v.References, HasLen, 3)
+	c.Assert(v.BestMatch("v0").Name().String(), Equals, "refs/heads/main")
+	c.Assert(v.Channel(&Channel{Rule: ChannelBranch}).Name().String(), Equals, "refs/heads/main")
+
+	v = NewVersionsWithConfig(refs, nil, &RepositoryConfig{Repository: "github.com/org/repository", DefaultBranch: "develop"})
+	c.Assert(v.DefaultBranch(), Equals, "develop")
+	c.Assert(v.BestMatch("v0").Name().String(), Equals, "refs/heads/develop")
+
+	delete(refs, plumbing.HEAD)
+	c.Assert(NewVersions(refs, nil).DefaultBranch(), Equals, "master")
+}
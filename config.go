@@ -0,0 +1,115 @@
+package stable
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Config is the runtime configuration of a Server, usually read from a JSON
+// file, see LoadConfig.
+type Config struct {
+	Retractions []*Retraction `json:"retractions"`
+	Pins        []*Pin        `json:"pins"`
+	// Repositories are the settings by repository or organization.
+	Repositories []*RepositoryConfig `json:"repositories,omitempty"`
+	// Channels replace the default channels, if any.
+	Channels []*Channel `json:"channels,omitempty"`
+	// Warmup are the packages refreshed at startup, by name, eg.:
+	// foo.bar/org/repository.v1, requires a Prefetcher.
+	Warmup []string `json:"warmup"`
+}
+
+// LoadConfig reads a Config from the given JSON file.
+func LoadConfig(filename string) (*Config, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	c := &Config{}
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Apply applies the configuration to the given Server.
+func (c *Config) Apply(s *Server) error {
+	for _, r := range c.Retractions {
+		if err := s.Retractions.Add(r); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range c.Pins {
+		if err := s.Pins.Add(p); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range c.Repositories {
+		if err := r.validate(); err != nil {
+			return err
+		}
+
+		s.Repositories = append(s.Repositories, r)
+	}
+
+	if len(c.Channels) != 0 {
+		channels := make(map[string]*Channel, len(c.Channels))
+		for _, ch := range c.Channels {
+			if err := ch.validate(); err != nil {
+				return fmt.Errorf("%s: %q", err, ch.Name)
+			}
+
+			channels[ch.Name] = ch
+		}
+
+		s.Channels = channels
+		s.buildRouter()
+	}
+
+	if len(c.Warmup) == 0 {
+		return nil
+	}
+
+	if s.Prefetcher == nil {
+		return fmt.Errorf("warmup requires a prefetcher")
+	}
+
+	for _, name := range c.Warmup {
+		if _, err := s.Package(name); err != nil {
+			return fmt.Errorf("invalid warmup package %q: %s", name, err)
+		}
+	}
+
+	s.Prefetcher.Warmup = append(s.Prefetcher.Warmup, c.Warmup...)
+	return nil
+}
+
+// Config returns the current configuration of the Server, including the
+// changes made at runtime, eg.: from the admin API.
+func (s *Server) Config() *Config {
+	c := &Config{
+		Retractions:  s.Retractions.List(),
+		Pins:         s.Pins.List(),
+		Repositories: s.Repositories,
+	}
+
+	for _, ch := range s.Channels {
+		c.Channels = append(c.Channels, ch)
+	}
+
+	sort.Sort(byChannel(c.Channels))
+
+	if s.Prefetcher != nil {
+		c.Warmup = s.Prefetcher.Warmup
+	}
+
+	return c
+}
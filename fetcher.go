@@ -1,29 +1,43 @@
 package stable
 
 import (
+	"context"
 	"io"
+	"net"
+	"net/http"
+	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp/capability"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
-	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 )
 
 type Fetcher struct {
-	pkg     *Package
-	service transport.UploadPackSession
-	auth    transport.AuthMethod
+	pkg    *Package
+	auth   transport.AuthMethod
+	client *http.Client
 }
 
 func NewFetcher(p *Package, auth transport.AuthMethod) *Fetcher {
+	return NewFetcherWithClient(http.DefaultClient, p, auth)
+}
 
-	s, _ := http.DefaultClient.NewUploadPackSession(p.Repository, auth)
-
-	return &Fetcher{pkg: p, service: s}
+// NewFetcherWithClient returns a Fetcher using the given http.Client for the
+// calls to the upstream, see NewUpstreamClient.
+func NewFetcherWithClient(c *http.Client, p *Package, auth transport.AuthMethod) *Fetcher {
+	return &Fetcher{pkg: p, auth: auth, client: c}
 }
 
-func (f *Fetcher) Versions() (Versions, error) {
-	info, err := f.service.AdvertisedReferences()
+func (f *Fetcher) Versions(ctx context.Context) (*Versions, error) {
+	s, err := f.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close()
+	info, err := s.AdvertisedReferences()
 	if err != nil {
 		return nil, err
 	}
@@ -33,17 +47,138 @@ func (f *Fetcher) Versions() (Versions, error) {
 		return nil, err
 	}
 
-	return NewVersions(refs), nil
+	return NewVersionsWithConfig(refs, info.Peeled, f.pkg.Config), nil
+}
+
+// Fetch writes to w the packfile containing the objects of the given refs, the
+// annotated tags objects are included when a tag reference is given.
+func (f *Fetcher) Fetch(ctx context.Context, w io.Writer, refs ...*plumbing.Reference) (written int64, err error) {
+	var wants []plumbing.Hash
+	for _, ref := range refs {
+		wants = append(wants, ref.Hash())
+	}
+
+	return f.fetch(ctx, w, 0, wants, nil)
+}
+
+// FetchShallow is like Fetch, but only the objects of the wanted commits are
+// fetched, without its history.
+func (f *Fetcher) FetchShallow(ctx context.Context, w io.Writer, wants ...plumbing.Hash) (written int64, err error) {
+	return f.fetch(ctx, w, 1, wants, nil)
 }
 
-func (f *Fetcher) Fetch(w io.Writer, ref *plumbing.Reference) (written int64, err error) {
+// FetchObjects writes to w the packfile containing the wanted objects, and the
+// objects reachable from them, not reachable from the haves.
+func (f *Fetcher) FetchObjects(ctx context.Context, w io.Writer, wants, haves []plumbing.Hash) (written int64, err error) {
+	return f.fetch(ctx, w, 0, wants, haves)
+}
+
+func (f *Fetcher) fetch(ctx context.Context, w io.Writer, depth int, wants, haves []plumbing.Hash) (int64, error) {
+	s, err := f.session(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer s.Close()
 	req := packp.NewUploadPackRequest()
-	req.Wants = []plumbing.Hash{ref.Hash()}
+	for _, h := range wants {
+		if !hasHash(req.Wants, h) {
+			req.Wants = append(req.Wants, h)
+		}
+	}
+
+	for _, h := range haves {
+		if !hasHash(req.Haves, h) {
+			req.Haves = append(req.Haves, h)
+		}
+	}
 
-	r, err := f.service.UploadPack(req)
+	if depth > 0 {
+		req.Capabilities.Set(capability.Shallow)
+		req.Depth = packp.DepthCommits(depth)
+	}
+
+	r, err := s.UploadPack(req)
 	if err != nil {
 		return 0, err
 	}
 
+	defer r.Close()
 	return io.Copy(w, r)
 }
+
+func hasHash(hashes []plumbing.Hash, h plumbing.Hash) bool {
+	for _, hash := range hashes {
+		if hash == h {
+			return true
+		}
+	}
+
+	return false
+}
+
+// session returns a new upload-pack session, every request made by the
+// session is bound to the given context.
+func (f *Fetcher) session(ctx context.Context) (transport.UploadPackSession, error) {
+	c := githttp.NewClient(&http.Client{
+		Transport: &contextTransport{ctx: ctx, t: f.client.Transport},
+		Timeout:   f.client.Timeout,
+	})
+
+	return c.NewUploadPackSession(f.pkg.Repository, f.auth)
+}
+
+type contextTransport struct {
+	ctx context.Context
+	t   http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt := t.t
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return rt.RoundTrip(r.WithContext(t.ctx))
+}
+
+// NewUpstreamClient returns a http.Client to be used with the upstream git
+// servers, connect limits the time to establish a connection and read the time
+// waiting for new data from the server. The transfer of a big packfile is not
+// limited, as long as the server keeps sending data.
+func NewUpstreamClient(connect, read time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+
+				return &readTimeoutConn{Conn: conn, timeout: read}, nil
+			},
+			TLSHandshakeTimeout:   connect,
+			ResponseHeaderTimeout: read,
+			MaxIdleConnsPerHost:   10,
+		},
+	}
+}
+
+// readTimeoutConn is a net.Conn extending the read deadline before every read.
+type readTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readTimeoutConn) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Read(b)
+}
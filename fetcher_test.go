@@ -2,6 +2,8 @@ package stable
 
 import (
 	"bytes"
+	"context"
+	"time"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -17,9 +19,9 @@ func (s *FetcherSuite) TestVersions(c *C) {
 	pkg.Repository, _ = transport.NewEndpoint("https://github.com/git-fixtures/basic")
 
 	f := NewFetcher(pkg, nil)
-	versions, err := f.Versions()
+	versions, err := f.Versions(context.Background())
 	c.Assert(err, IsNil)
-	c.Assert(versions, HasLen, 2)
+	c.Assert(versions.References, HasLen, 2)
 }
 
 func (s *FetcherSuite) TestFetch(c *C) {
@@ -31,8 +33,48 @@ func (s *FetcherSuite) TestFetch(c *C) {
 	ref := plumbing.NewReferenceFromStrings("foo", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
 
 	buf := bytes.NewBuffer(nil)
-	n, err := f.Fetch(buf, ref)
+	n, err := f.Fetch(context.Background(), buf, ref)
 	c.Assert(err, IsNil)
 	c.Assert(n, Equals, int64(85374))
 	c.Assert(buf.Len(), Equals, 85374)
 }
+
+func (s *FetcherSuite) TestVersionsCanceled(c *C) {
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/git-fixtures/basic")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	f := NewFetcher(pkg, nil)
+	versions, err := f.Versions(ctx)
+	c.Assert(err, NotNil)
+	c.Assert(versions, IsNil)
+}
+
+func (s *FetcherSuite) TestVersionsDeadlineWithUpstreamClient(c *C) {
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/git-fixtures/basic")
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	f := NewFetcherWithClient(NewUpstreamClient(10*time.Second, 30*time.Second), pkg, nil)
+	versions, err := f.Versions(ctx)
+	c.Assert(isCanceled(err), Equals, true)
+	c.Assert(versions, IsNil)
+}
+
+func (s *FetcherSuite) TestFetchWithUpstreamClient(c *C) {
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/git-fixtures/basic")
+
+	f := NewFetcherWithClient(NewUpstreamClient(10*time.Second, 30*time.Second), pkg, nil)
+
+	ref := plumbing.NewReferenceFromStrings("foo", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	buf := bytes.NewBuffer(nil)
+	n, err := f.Fetch(context.Background(), buf, ref)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(85374))
+}
@@ -0,0 +1,228 @@
+package stable
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// GoModRetractions excludes the versions retracted by the module authors, using
+// the retract directives from the go.mod file of the newest tag of each major,
+// applied to the versions of the same major. The result is cached by
+// repository, until a new tag is published.
+type GoModRetractions struct {
+	mu    sync.Mutex
+	cache map[string]*goModRetractionsEntry
+}
+
+type goModRetractionsEntry struct {
+	// newest are the newest tags of each major, as computed by Prepare, with
+	// the commit they were read from.
+	newest   map[string]plumbing.Hash
+	excluded map[string]string
+}
+
+func NewGoModRetractions() *GoModRetractions {
+	return &GoModRetractions{cache: make(map[string]*goModRetractionsEntry, 0)}
+}
+
+// Prepare reads the retractions from the go.mod files if the newest tags
+// changed since the last call, the go.mod files are fetched using f, if the
+// limiter allows it.
+func (g *GoModRetractions) Prepare(ctx context.Context, f *Fetcher, l *RateLimiter, pkg *Package, v *Versions) error {
+	if g == nil {
+		return nil
+	}
+
+	newest := g.newest(v)
+	if len(newest) == 0 {
+		return nil
+	}
+
+	repository := pkg.RepositoryName()
+
+	g.mu.Lock()
+	entry, ok := g.cache[repository]
+	g.mu.Unlock()
+
+	if ok && entry.isNewest(newest) {
+		return nil
+	}
+
+	excluded, err := g.read(ctx, f, l, pkg, v, newest)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.cache[repository] = &goModRetractionsEntry{newest: newest, excluded: excluded}
+	g.mu.Unlock()
+
+	return nil
+}
+
+// Exclude returns a copy of the given Versions with the versions retracted in
+// the go.mod files excluded. Only the results of Prepare are used, nothing is
+// excluded if the go.mod files read by Prepare are from tags no longer
+// advertised, or moved.
+func (g *GoModRetractions) Exclude(pkg *Package, v *Versions) *Versions {
+	if g == nil {
+		return v
+	}
+
+	g.mu.Lock()
+	entry, ok := g.cache[pkg.RepositoryName()]
+	g.mu.Unlock()
+
+	if !ok || !entry.isValid(v) {
+		return v
+	}
+
+	return v.Exclude(entry.excluded)
+}
+
+// isNewest returns true if the go.mod files were read from the given newest
+// tags.
+func (e *goModRetractionsEntry) isNewest(newest map[string]plumbing.Hash) bool {
+	if len(e.newest) != len(newest) {
+		return false
+	}
+
+	for name, commit := range newest {
+		if e.newest[name] != commit {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isValid returns true if the newest tags read are still advertised, not
+// excluded and pointing to the same commit. It doesn't compute the majors, the
+// new tags are read by Prepare, every time the upstream is called.
+func (e *goModRetractionsEntry) isValid(v *Versions) bool {
+	for name, commit := range e.newest {
+		ref, ok := v.References[name]
+		if !ok || v.IsExcluded(name) || v.Peel(ref).Hash() != commit {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newest returns the newest tag of each major, with the commit it points to.
+func (g *GoModRetractions) newest(v *Versions) map[string]plumbing.Hash {
+	newest := make(map[string]plumbing.Hash, 0)
+	for _, ref := range v.Mayor() {
+		if ref.IsTag() {
+			newest[ref.Name().Short()] = v.Peel(ref).Hash()
+		}
+	}
+
+	return newest
+}
+
+func (g *GoModRetractions) read(ctx context.Context, f *Fetcher, l *RateLimiter, pkg *Package, v *Versions, newest map[string]plumbing.Hash) (map[string]string, error) {
+	var names []string
+	for name := range newest {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	excluded := make(map[string]string, 0)
+	for _, name := range names {
+		if !l.AllowUpstream(pkg.Repository.Host) {
+			return nil, ErrRateLimited
+		}
+
+		s, err := f.Snapshot(ctx, newest[name])
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := s.File("go.mod")
+		if err == ErrFileNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		retracted, err := parseRetractions(v, name, content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid go.mod at %s: %s", name, err)
+		}
+
+		for tag, reason := range retracted {
+			excluded[tag] = reason
+		}
+	}
+
+	return excluded, nil
+}
+
+// parseRetractions returns the tags retracted by the retract directives of the
+// go.mod content read from the given tag, with the reason of the exclusion. A
+// go.mod only retracts the versions of its own major, the module path of v0
+// and v1 is the same, so they retract each other versions.
+func parseRetractions(v *Versions, name string, content []byte) (map[string]string, error) {
+	file, err := modfile.ParseLax("go.mod", content, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	major := moduleMajor(v.Version(name))
+
+	excluded := make(map[string]string, 0)
+	for _, r := range file.Retract {
+		for tag, ref := range v.References {
+			if !ref.IsTag() || moduleMajor(v.Version(tag)) != major {
+				continue
+			}
+
+			if isInInterval(v.Version(tag), r.Low, r.High) {
+				excluded[tag] = "retracted in go.mod: " + r.Rationale
+			}
+		}
+	}
+
+	return excluded, nil
+}
+
+// moduleMajor returns the major of the module path of the given version, v0 is
+// v1, since both share the module path.
+func moduleMajor(version string) string {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	major := semver.Major(version)
+	if major == "v0" {
+		return "v1"
+	}
+
+	return major
+}
+
+// isInInterval returns true if the tag, as semantic version, is inside of the
+// interval [low, high].
+func isInInterval(tag, low, high string) bool {
+	if !strings.HasPrefix(tag, "v") {
+		tag = "v" + tag
+	}
+
+	if !semver.IsValid(tag) {
+		return false
+	}
+
+	return semver.Compare(tag, low) >= 0 && semver.Compare(tag, high) <= 0
+}
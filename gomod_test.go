@@ -0,0 +1,148 @@
+package stable
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type GoModSuite struct{}
+
+var _ = Suite(&GoModSuite{})
+
+func (s *GoModSuite) TestIsInInterval(c *C) {
+	c.Assert(isInInterval("v1.0.0", "v1.0.0", "v1.0.0"), Equals, true)
+	c.Assert(isInInterval("1.0.1", "v1.0.0", "v1.0.5"), Equals, true)
+	c.Assert(isInInterval("v1.0.6", "v1.0.0", "v1.0.5"), Equals, false)
+	c.Assert(isInInterval("v1.1.0-rc1", "v1.0.0", "v1.0.5"), Equals, false)
+	c.Assert(isInInterval("master", "v1.0.0", "v1.0.5"), Equals, false)
+}
+
+func (s *GoModSuite) TestExcludeCached(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.1", plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")))
+	v := NewVersions(refs, nil)
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	g := NewGoModRetractions()
+	g.cache["github.com/org/repository"] = &goModRetractionsEntry{
+		newest:   map[string]plumbing.Hash{"v1.0.1": plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")},
+		excluded: map[string]string{"v1.0.1": "retracted in go.mod: broken"},
+	}
+
+	// the newest tags didn't change, so the upstream is never called
+	c.Assert(g.Prepare(context.Background(), nil, nil, pkg, v), IsNil)
+
+	excluded := g.Exclude(pkg, v)
+	c.Assert(excluded.BestMatch("v1").Name().String(), Equals, "refs/tags/v1.0.0")
+	c.Assert(excluded.Excluded["v1.0.1"], Equals, "retracted in go.mod: broken")
+}
+
+func (s *GoModSuite) TestExcludeMoved(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.1", plumbing.NewHash("f07474b57ed4c07fd2d8b474a08804fffbe1731c")))
+	v := NewVersions(refs, nil)
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	g := NewGoModRetractions()
+	g.cache["github.com/org/repository"] = &goModRetractionsEntry{
+		newest:   map[string]plumbing.Hash{"v1.0.1": plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")},
+		excluded: map[string]string{"v1.0.1": "retracted in go.mod: broken"},
+	}
+
+	// the go.mod was read from a commit the tag no longer points to
+	c.Assert(g.Exclude(pkg, v), Equals, v)
+}
+
+func (s *GoModSuite) TestExcludeNil(c *C) {
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+
+	var g *GoModRetractions
+	c.Assert(g.Prepare(context.Background(), nil, nil, nil, v), IsNil)
+	c.Assert(g.Exclude(nil, v), Equals, v)
+}
+
+func (s *GoModSuite) TestPrepareRateLimited(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")))
+	v := NewVersions(refs, nil)
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	l := NewRateLimiter(0, 0, 0.001, 1)
+	l.AllowUpstream("github.com")
+
+	g := NewGoModRetractions()
+	c.Assert(g.Prepare(context.Background(), nil, l, pkg, v), Equals, ErrRateLimited)
+	c.Assert(g.cache, HasLen, 0)
+	c.Assert(g.Exclude(pkg, v), Equals, v)
+}
+
+const fixtureGoMod = `module github.com/org/repository
+
+go 1.16
+
+require golang.org/x/mod v0.4.2
+
+retract (
+	v1.0.1 // data corruption
+	[v1.1.0, v1.1.5] // broken build
+)
+`
+
+func (s *GoModSuite) TestParseRetractions(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	for _, tag := range []string{"v1.0.0", "v1.0.1", "v1.1.0", "v1.1.3", "v1.1.6"} {
+		refs.SetReference(plumbing.NewHashReference(plumbing.ReferenceName("refs/tags/"+tag), plumbing.NewHash("")))
+	}
+
+	refs.SetReference(plumbing.NewHashReference("refs/heads/v1.1.2", plumbing.NewHash("")))
+	v := NewVersions(refs, nil)
+
+	excluded, err := parseRetractions(v, "v1.1.6", []byte(fixtureGoMod))
+	c.Assert(err, IsNil)
+	c.Assert(excluded, DeepEquals, map[string]string{
+		"v1.0.1": "retracted in go.mod: data corruption",
+		"v1.1.0": "retracted in go.mod: broken build",
+		"v1.1.3": "retracted in go.mod: broken build",
+	})
+
+	_, err = parseRetractions(v, "v1.1.6", []byte("retract [v1.0.0"))
+	c.Assert(err, NotNil)
+}
+
+func (s *GoModSuite) TestParseRetractionsMajors(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	for _, tag := range []string{"v0.9.0", "v1.0.1", "v1.1.0", "v2.0.1", "v2.1.0"} {
+		refs.SetReference(plumbing.NewHashReference(plumbing.ReferenceName("refs/tags/"+tag), plumbing.NewHash("")))
+	}
+
+	v := NewVersions(refs, nil)
+	content := []byte("retract [v0.0.0, v2.0.5] // broken\n")
+
+	// the go.mod of v2 only retracts v2 versions
+	excluded, err := parseRetractions(v, "v2.1.0", content)
+	c.Assert(err, IsNil)
+	c.Assert(excluded, DeepEquals, map[string]string{
+		"v2.0.1": "retracted in go.mod: broken",
+	})
+
+	// the go.mod of v1 retracts v0 and v1 versions, sharing the module path
+	excluded, err = parseRetractions(v, "v1.1.0", content)
+	c.Assert(err, IsNil)
+	c.Assert(excluded, DeepEquals, map[string]string{
+		"v0.9.0": "retracted in go.mod: broken",
+		"v1.0.1": "retracted in go.mod: broken",
+		"v1.1.0": "retracted in go.mod: broken",
+	})
+}
@@ -0,0 +1,56 @@
+package stable
+
+import (
+	"regexp"
+
+	"github.com/mcuadros/go-version"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// SchemeGopkgIn resolves the versions following the rules of gopkg.in: a vN
+// branch takes precedence over the tags of the major N, otherwise the highest
+// tag named vN, vN.N or vN.N.N is used.
+const SchemeGopkgIn = "gopkg.in"
+
+var gopkgInVersionRegexp = regexp.MustCompile(`^v[0-9]+(\.[0-9]+){0,2}$`)
+
+// IsGopkgIn returns true if the versions follow the gopkg.in rules.
+func (c *RepositoryConfig) IsGopkgIn() bool {
+	return c != nil && c.Scheme == SchemeGopkgIn
+}
+
+// precedes returns true if ref takes precedence over other when both have the
+// same short name, eg.: the branch v1 and the tag v1. The branch is preferred
+// following the gopkg.in rules, otherwise the tag.
+func (c *RepositoryConfig) precedes(ref, other *plumbing.Reference) bool {
+	if c.IsGopkgIn() {
+		return ref.IsBranch() && !other.IsBranch()
+	}
+
+	return ref.IsTag() && !other.IsTag()
+}
+
+// matchGopkgIn returns the short names of the tags named vN, vN.N or vN.N.N
+// matching the given constraint, the branches are only resolved by its exact
+// name, eg.: v1
+func (v *Versions) matchGopkgIn(needed string) []string {
+	c := newConstrain(needed)
+
+	var names []string
+	for name, ref := range v.References {
+		if !ref.IsTag() || v.IsExcluded(name) {
+			continue
+		}
+
+		tag := v.Version(name)
+		if !gopkgInVersionRegexp.MatchString(tag) {
+			continue
+		}
+
+		if c.Match(version.Normalize(tag)) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
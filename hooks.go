@@ -0,0 +1,158 @@
+package stable
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var ErrInvalidHookPayload = errors.New("invalid webhook payload")
+
+// Hooks are the secrets of the webhooks notifying the pushes to the upstream
+// repositories, a provider with an empty secret is disabled.
+type Hooks struct {
+	// GitHub is the secret used to sign the payloads, X-Hub-Signature-256.
+	GitHub string
+	// GitLab is the secret token sent at X-Gitlab-Token.
+	GitLab string
+	// Gitea is the secret used to sign the payloads, X-Gitea-Signature.
+	Gitea string
+}
+
+// hookPayload holds the fields of the push, tag and create events used to
+// identify the repository, from any of the supported providers.
+type hookPayload struct {
+	Repository struct {
+		HTMLURL string `json:"html_url"`
+	} `json:"repository"`
+	Project struct {
+		WebURL string `json:"web_url"`
+	} `json:"project"`
+}
+
+// RepositoryName returns the name of the repository of the event, without
+// scheme, eg.: github.com/org/repository, the port is kept as it's done by
+// Package.RepositoryName.
+func (p *hookPayload) RepositoryName() (string, error) {
+	rawurl := p.Repository.HTMLURL
+	if rawurl == "" {
+		rawurl = p.Project.WebURL
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Hostname() == "" || u.Path == "" {
+		return "", ErrInvalidHookPayload
+	}
+
+	return u.Host + strings.TrimSuffix(u.Path, ".git"), nil
+}
+
+func (s *Server) doGitHubHookResponse(w http.ResponseWriter, r *http.Request) {
+	if s.Hooks == nil || s.Hooks.GitHub == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.doHookResponse(w, r, r.Header.Get("X-GitHub-Event"), func(body []byte) bool {
+		signature := r.Header.Get("X-Hub-Signature-256")
+		return strings.HasPrefix(signature, "sha256=") &&
+			isValidHMAC(s.Hooks.GitHub, body, strings.TrimPrefix(signature, "sha256="))
+	}, "push", "create", "delete")
+}
+
+func (s *Server) doGitLabHookResponse(w http.ResponseWriter, r *http.Request) {
+	if s.Hooks == nil || s.Hooks.GitLab == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.doHookResponse(w, r, r.Header.Get("X-Gitlab-Event"), func(body []byte) bool {
+		token := r.Header.Get("X-Gitlab-Token")
+		return subtle.ConstantTimeCompare([]byte(token), []byte(s.Hooks.GitLab)) == 1
+	}, "Push Hook", "Tag Push Hook")
+}
+
+func (s *Server) doGiteaHookResponse(w http.ResponseWriter, r *http.Request) {
+	if s.Hooks == nil || s.Hooks.Gitea == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.doHookResponse(w, r, r.Header.Get("X-Gitea-Event"), func(body []byte) bool {
+		return isValidHMAC(s.Hooks.Gitea, body, r.Header.Get("X-Gitea-Signature"))
+	}, "push", "create", "delete")
+}
+
+// doHookResponse validates the webhook request and invalidates the cached
+// Versions of the repository if the event is one of the given ones, any other
+// event is accepted and ignored.
+func (s *Server) doHookResponse(
+	w http.ResponseWriter, r *http.Request, event string,
+	isValid func(body []byte) bool, events ...string,
+) {
+	body, err := ioutil.ReadAll(&maxSizeReader{r: r.Body, n: DefaultMaxRequestSize})
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	if !isValid(body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !hasEvent(event, events) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	payload := &hookPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		http.Error(w, ErrInvalidHookPayload.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repository, err := payload.RepositoryName()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.Cache.Invalidate(repository) {
+		fmt.Fprintf(os.Stderr, "cached versions of %s invalidated by %s event\n", repository, event)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isValidHMAC returns true if signature is the hex encoded HMAC-SHA256 of the
+// body with the given secret.
+func isValidHMAC(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func hasEvent(event string, events []string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+package stable
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type HooksSuite struct{}
+
+var _ = Suite(&HooksSuite{})
+
+func (s *HooksSuite) newServer() *Server {
+	srv := NewDefaultServer("foo.bar")
+	srv.Cache = NewVersionsCache(time.Minute)
+	srv.Hooks = &Hooks{GitHub: "secret", GitLab: "secret", Gitea: "secret"}
+
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+	srv.Cache.Set("github.com/org/repository", "foo", v, time.Now())
+	srv.Cache.Set("gitlab.com/org/repository", "foo", v, time.Now())
+	return srv
+}
+
+func (s *HooksSuite) do(srv *Server, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *HooksSuite) sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *HooksSuite) isCached(srv *Server, repository string) bool {
+	_, ok := srv.Cache.Get(repository, "foo")
+	return ok
+}
+
+func (s *HooksSuite) TestGitHub(c *C) {
+	srv := s.newServer()
+	body := `{"ref":"refs/tags/v1.0.0","repository":{"html_url":"https://github.com/org/repository"}}`
+
+	w := s.do(srv, "http://localhost/hooks/github", body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=" + s.sign("foo", body),
+	})
+
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+	c.Assert(s.isCached(srv, "github.com/org/repository"), Equals, true)
+
+	w = s.do(srv, "http://localhost/hooks/github", body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=" + s.sign("secret", body),
+	})
+
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(s.isCached(srv, "github.com/org/repository"), Equals, false)
+}
+
+func (s *HooksSuite) TestGitHubIgnoredEvent(c *C) {
+	srv := s.newServer()
+	body := `{"repository":{"html_url":"https://github.com/org/repository"}}`
+
+	w := s.do(srv, "http://localhost/hooks/github", body, map[string]string{
+		"X-GitHub-Event":      "issues",
+		"X-Hub-Signature-256": "sha256=" + s.sign("secret", body),
+	})
+
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(s.isCached(srv, "github.com/org/repository"), Equals, true)
+}
+
+func (s *HooksSuite) TestGitLab(c *C) {
+	srv := s.newServer()
+	body := `{"ref":"refs/tags/v1.0.0","project":{"web_url":"https://gitlab.com/org/repository"}}`
+
+	w := s.do(srv, "http://localhost/hooks/gitlab", body, map[string]string{
+		"X-Gitlab-Event": "Tag Push Hook",
+		"X-Gitlab-Token": "foo",
+	})
+
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+
+	w = s.do(srv, "http://localhost/hooks/gitlab", body, map[string]string{
+		"X-Gitlab-Event": "Tag Push Hook",
+		"X-Gitlab-Token": "secret",
+	})
+
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(s.isCached(srv, "gitlab.com/org/repository"), Equals, false)
+	c.Assert(s.isCached(srv, "github.com/org/repository"), Equals, true)
+}
+
+func (s *HooksSuite) TestGitea(c *C) {
+	srv := s.newServer()
+	body := `{"ref":"v1.0.0","ref_type":"tag","repository":{"html_url":"https://github.com/org/repository"}}`
+
+	w := s.do(srv, "http://localhost/hooks/gitea", body, map[string]string{
+		"X-Gitea-Event":     "create",
+		"X-Gitea-Signature": s.sign("secret", body),
+	})
+
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(s.isCached(srv, "github.com/org/repository"), Equals, false)
+}
+
+func (s *HooksSuite) TestInvalidPayload(c *C) {
+	srv := s.newServer()
+	body := `{"repository":{}}`
+
+	w := s.do(srv, "http://localhost/hooks/github", body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=" + s.sign("secret", body),
+	})
+
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *HooksSuite) TestDisabled(c *C) {
+	srv := NewDefaultServer("foo.bar")
+
+	w := s.do(srv, "http://localhost/hooks/github", "{}", nil)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+}
+
+func (s *HooksSuite) TestGitLabCaseAndPort(c *C) {
+	srv := s.newServer()
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+	srv.Cache.Set("gitlab.example.com:8443/org/repository", "foo", v, time.Now())
+
+	body := `{"ref":"refs/tags/v1.0.0","project":{"web_url":"https://GitLab.example.com:8443/Org/Repository"}}`
+	w := s.do(srv, "http://localhost/hooks/gitlab", body, map[string]string{
+		"X-Gitlab-Event": "Tag Push Hook",
+		"X-Gitlab-Token": "secret",
+	})
+
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+	c.Assert(s.isCached(srv, "gitlab.example.com:8443/org/repository"), Equals, false)
+	c.Assert(s.isCached(srv, "gitlab.com/org/repository"), Equals, true)
+}
+
+func (s *HooksSuite) TestRepositoryName(c *C) {
+	p := &hookPayload{}
+	p.Repository.HTMLURL = "https://gitea.example.com:3000/org/repository.git"
+
+	name, err := p.RepositoryName()
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "gitea.example.com:3000/org/repository")
+}
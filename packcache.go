@@ -0,0 +1,201 @@
+package stable
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// PackCache stores at a directory the packfiles fetched from the upstream, by
+// repository and wanted objects. Since the objects are immutable, the packfiles
+// never expire, but the least recently used are removed when the size of the
+// directory exceeds MaxSize.
+type PackCache struct {
+	Dir string
+	// MaxSize is the max size in bytes of the packfiles stored, unlimited if
+	// zero.
+	MaxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*packCacheEntry
+	size    int64
+}
+
+type packCacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// NewPackCache returns a PackCache at the given directory, creating it if
+// needed, the packfiles already stored are kept, by modification time.
+func NewPackCache(dir string, maxSize int64) (*PackCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PackCache{
+		Dir:     dir,
+		MaxSize: maxSize,
+		entries: make(map[string]*packCacheEntry, 0),
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".pack" {
+			continue
+		}
+
+		filename := filepath.Join(dir, file.Name())
+		c.entries[filename] = &packCacheEntry{size: file.Size(), used: file.ModTime()}
+		c.size += file.Size()
+	}
+
+	c.evict("")
+	return c, nil
+}
+
+// Has returns true if the packfile of the given refs is cached.
+func (c *PackCache) Has(repository string, refs ...*plumbing.Reference) bool {
+	if c == nil {
+		return false
+	}
+
+	_, err := os.Stat(c.filename(repository, refs))
+	return err == nil
+}
+
+// Fetch writes to w the packfile of the given refs, from the cache if present,
+// otherwise the packfile is fetched using f and stored at the cache.
+func (c *PackCache) Fetch(ctx context.Context, f *Fetcher, w io.Writer, refs ...*plumbing.Reference) (int64, error) {
+	if c == nil {
+		return f.Fetch(ctx, w, refs...)
+	}
+
+	filename := c.filename(f.pkg.RepositoryName(), refs)
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+		c.touch(file)
+		return io.Copy(w, file)
+	}
+
+	tmp, err := ioutil.TempFile(c.Dir, "tmp-")
+	if err != nil {
+		return 0, err
+	}
+
+	defer os.Remove(tmp.Name())
+	n, err := f.Fetch(ctx, io.MultiWriter(w, tmp), refs...)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return n, err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return n, err
+	}
+
+	c.add(filename, n)
+	return n, nil
+}
+
+// touch marks the given packfile as used, the modification time is updated
+// too, so the order is kept across restarts.
+func (c *PackCache) touch(file *os.File) {
+	now := time.Now()
+	os.Chtimes(file.Name(), now, now)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[file.Name()]; ok {
+		e.used = now
+		return
+	}
+
+	// stored by a previous Fetch, or by other process
+	if info, err := file.Stat(); err == nil {
+		c.entries[file.Name()] = &packCacheEntry{size: info.Size(), used: now}
+		c.size += info.Size()
+	}
+
+	c.evictLocked(file.Name())
+}
+
+func (c *PackCache) add(filename string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[filename]; ok {
+		c.size -= e.size
+	}
+
+	c.entries[filename] = &packCacheEntry{size: size, used: time.Now()}
+	c.size += size
+	c.evictLocked(filename)
+}
+
+// evict removes the least recently used packfiles, except keep, until the size
+// is below MaxSize.
+func (c *PackCache) evict(keep string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictLocked(keep)
+}
+
+func (c *PackCache) evictLocked(keep string) {
+	if c.MaxSize <= 0 || c.size <= c.MaxSize {
+		return
+	}
+
+	var filenames []string
+	for filename := range c.entries {
+		if filename != keep {
+			filenames = append(filenames, filename)
+		}
+	}
+
+	sort.Slice(filenames, func(i, j int) bool {
+		return c.entries[filenames[i]].used.Before(c.entries[filenames[j]].used)
+	})
+
+	for _, filename := range filenames {
+		if c.size <= c.MaxSize {
+			return
+		}
+
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "error removing cached packfile %s: %s\n", filename, err)
+			continue
+		}
+
+		c.size -= c.entries[filename].size
+		delete(c.entries, filename)
+	}
+}
+
+func (c *PackCache) filename(repository string, refs []*plumbing.Reference) string {
+	h := sha1.New()
+	fmt.Fprintln(h, repository)
+	for _, ref := range refs {
+		fmt.Fprintln(h, ref.Hash())
+	}
+
+	return filepath.Join(c.Dir, fmt.Sprintf("%x.pack", h.Sum(nil)))
+}
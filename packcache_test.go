@@ -0,0 +1,113 @@
+package stable
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+type PackCacheSuite struct {
+	dir string
+}
+
+var _ = Suite(&PackCacheSuite{})
+
+func (s *PackCacheSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "go-stable-packs")
+	c.Assert(err, IsNil)
+}
+
+func (s *PackCacheSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *PackCacheSuite) TestFetchCached(c *C) {
+	cache, err := NewPackCache(s.dir, 0)
+	c.Assert(err, IsNil)
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	ref := plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(cache.Has("github.com/org/repository", ref), Equals, false)
+
+	filename := cache.filename("github.com/org/repository", []*plumbing.Reference{ref})
+	c.Assert(ioutil.WriteFile(filename, []byte("PACK"), 0600), IsNil)
+	c.Assert(cache.Has("github.com/org/repository", ref), Equals, true)
+	c.Assert(cache.Has("github.com/org/other", ref), Equals, false)
+
+	buf := bytes.NewBuffer(nil)
+	n, err := cache.Fetch(context.Background(), NewFetcher(pkg, nil), buf, ref)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(4))
+	c.Assert(buf.String(), Equals, "PACK")
+}
+
+func (s *PackCacheSuite) TestFetchError(c *C) {
+	cache, err := NewPackCache(s.dir, 0)
+	c.Assert(err, IsNil)
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ref := plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	_, err = cache.Fetch(ctx, NewFetcher(pkg, nil), ioutil.Discard, ref)
+	c.Assert(err, NotNil)
+	c.Assert(cache.Has("github.com/org/repository", ref), Equals, false)
+
+	files, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+func (s *PackCacheSuite) TestEvict(c *C) {
+	old := filepath.Join(s.dir, "old.pack")
+	c.Assert(ioutil.WriteFile(old, []byte("PACK"), 0600), IsNil)
+	c.Assert(os.Chtimes(old, time.Unix(0, 0), time.Unix(0, 0)), IsNil)
+
+	recent := filepath.Join(s.dir, "recent.pack")
+	c.Assert(ioutil.WriteFile(recent, []byte("PACK"), 0600), IsNil)
+
+	cache, err := NewPackCache(s.dir, 10)
+	c.Assert(err, IsNil)
+	c.Assert(cache.size, Equals, int64(8))
+
+	// the least recently used is removed, never the one just stored
+	added := filepath.Join(s.dir, "added.pack")
+	c.Assert(ioutil.WriteFile(added, []byte("PACK"), 0600), IsNil)
+	cache.add(added, 4)
+
+	_, err = os.Stat(old)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(recent)
+	c.Assert(err, IsNil)
+	_, err = os.Stat(added)
+	c.Assert(err, IsNil)
+	c.Assert(cache.size, Equals, int64(8))
+}
+
+func (s *PackCacheSuite) TestNewPackCacheEvict(c *C) {
+	for _, name := range []string{"a.pack", "b.pack", "c.pack"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(s.dir, name), []byte("PACK"), 0600), IsNil)
+	}
+
+	cache, err := NewPackCache(s.dir, 8)
+	c.Assert(err, IsNil)
+	c.Assert(cache.size, Equals, int64(8))
+	c.Assert(cache.entries, HasLen, 2)
+
+	files, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 2)
+}
@@ -0,0 +1,163 @@
+package stable
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mcuadros/go-version"
+)
+
+var (
+	ErrInvalidPin            = errors.New("invalid pin, repository, constraint and tag are required")
+	ErrPinConstraintMismatch = errors.New("invalid pin, the tag doesn't match the constraint")
+)
+
+// pinVersionRegexp matches the version at the end of a tag name, eg.: v2.4.1
+// or release-2.4.1
+var pinVersionRegexp = regexp.MustCompile(`[0-9]+(\.[0-9]+)*(-[0-9A-Za-z.-]+)?$`)
+
+// Pin freezes a constraint of a repository to a tag, eg.: repository.v2 to
+// v2.4.1, even if newer versions are available upstream.
+type Pin struct {
+	// Repository name without scheme, eg.: github.com/org/repository
+	Repository string `json:"repository"`
+	// Constraint as is requested at the URL, eg.: v2
+	Constraint string `json:"constraint"`
+	// Tag short name, eg.: v2.4.1
+	Tag    string `json:"tag"`
+	Reason string `json:"reason,omitempty"`
+	// Expires is the time when the pin is removed, if any.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (p *Pin) validate() error {
+	if p.Repository == "" || p.Constraint == "" || p.Tag == "" {
+		return ErrInvalidPin
+	}
+
+	if versionNameRegexp.MatchString(p.Constraint) && !p.matchesConstraint() {
+		return ErrPinConstraintMismatch
+	}
+
+	return nil
+}
+
+// matchesConstraint returns true if the version of the tag matches the
+// constraint, so a tag of other major is never served, eg.: v1.0.0 as v2
+func (p *Pin) matchesConstraint() bool {
+	v := pinVersionRegexp.FindString(p.Tag)
+	if v == "" {
+		return false
+	}
+
+	return newConstrain(p.Constraint).Match(version.Normalize(v))
+}
+
+// IsExpired returns true if the pin has an expiry time and it's reached.
+func (p *Pin) IsExpired() bool {
+	return p.Expires != nil && !time.Now().Before(*p.Expires)
+}
+
+// Pins is a store of pins, safe for concurrent use, the expired pins are
+// ignored and removed.
+type Pins struct {
+	mu sync.Mutex
+	m  map[string]map[string]*Pin
+}
+
+func NewPins() *Pins {
+	return &Pins{m: make(map[string]map[string]*Pin, 0)}
+}
+
+// Add adds or replaces a pin.
+func (p *Pins) Add(pin *Pin) error {
+	if err := pin.validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.m[pin.Repository]; !ok {
+		p.m[pin.Repository] = make(map[string]*Pin, 0)
+	}
+
+	p.m[pin.Repository][pin.Constraint] = pin
+	return nil
+}
+
+// Remove removes a pin, returns false if the pin didn't exists.
+func (p *Pins) Remove(repository, constraint string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, ok := p.m[repository][constraint]
+	if !ok {
+		return false
+	}
+
+	p.remove(pin)
+	return !pin.IsExpired()
+}
+
+// remove removes the given pin, p.mu should be held.
+func (p *Pins) remove(pin *Pin) {
+	delete(p.m[pin.Repository], pin.Constraint)
+	if len(p.m[pin.Repository]) == 0 {
+		delete(p.m, pin.Repository)
+	}
+}
+
+// Get returns the pin of the given repository and constraint, nil if none.
+func (p *Pins) Get(repository, constraint string) *Pin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pin, ok := p.m[repository][constraint]
+	if !ok {
+		return nil
+	}
+
+	if pin.IsExpired() {
+		p.remove(pin)
+		return nil
+	}
+
+	return pin
+}
+
+// List returns all the pins sorted by repository and constraint.
+func (p *Pins) List() []*Pin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	output := make([]*Pin, 0)
+	for _, constraints := range p.m {
+		for _, pin := range constraints {
+			if pin.IsExpired() {
+				p.remove(pin)
+				continue
+			}
+
+			output = append(output, pin)
+		}
+	}
+
+	sort.Sort(byPin(output))
+	return output
+}
+
+type byPin []*Pin
+
+func (s byPin) Len() int      { return len(s) }
+func (s byPin) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byPin) Less(i, j int) bool {
+	if s[i].Repository != s[j].Repository {
+		return s[i].Repository < s[j].Repository
+	}
+
+	return s[i].Constraint < s[j].Constraint
+}
@@ -0,0 +1,79 @@
+package stable
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type PinsSuite struct{}
+
+var _ = Suite(&PinsSuite{})
+
+func (s *PinsSuite) TestAddRemove(c *C) {
+	p := NewPins()
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/b", Constraint: "v2", Tag: "v2.4.1"}), IsNil)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v2", Tag: "v2.0.0"}), IsNil)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v1", Tag: "v1.0.0"}), IsNil)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v1"}), Equals, ErrInvalidPin)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v2", Tag: "v1.0.0"}), Equals, ErrPinConstraintMismatch)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v2.4", Tag: "v2.5.0"}), Equals, ErrPinConstraintMismatch)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v2", Tag: "master"}), Equals, ErrPinConstraintMismatch)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/c", Constraint: "v2", Tag: "release-2.0.0-rc1"}), IsNil)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/c", Constraint: "stable", Tag: "v1.0.0"}), IsNil)
+
+	list := p.List()
+	c.Assert(list, HasLen, 5)
+	c.Assert(list[0].Tag, Equals, "v1.0.0")
+	c.Assert(list[1].Tag, Equals, "v2.0.0")
+	c.Assert(list[2].Tag, Equals, "v2.4.1")
+
+	c.Assert(p.Remove("github.com/org/a", "v1"), Equals, true)
+	c.Assert(p.Remove("github.com/org/a", "v1"), Equals, false)
+	c.Assert(p.Get("github.com/org/a", "v1"), IsNil)
+	c.Assert(p.Get("github.com/org/a", "v2").Tag, Equals, "v2.0.0")
+}
+
+func (s *PinsSuite) TestExpired(c *C) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	p := NewPins()
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v1", Tag: "v1.0.0", Expires: &past}), IsNil)
+	c.Assert(p.Add(&Pin{Repository: "github.com/org/a", Constraint: "v2", Tag: "v2.0.0", Expires: &future}), IsNil)
+
+	c.Assert(p.Get("github.com/org/a", "v1"), IsNil)
+	c.Assert(p.Get("github.com/org/a", "v2").Tag, Equals, "v2.0.0")
+	c.Assert(p.List(), HasLen, 1)
+}
+
+func (s *PinsSuite) TestResolve(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.4.1", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.5.0", plumbing.NewHash("")))
+	v := NewVersions(refs, nil)
+
+	server := NewDefaultServer("foo.bar")
+	pkg, err := server.Package("foo.bar/org/repository.v2")
+	c.Assert(err, IsNil)
+
+	ref, pin := server.resolve(pkg, v)
+	c.Assert(ref.Name().Short(), Equals, "v2.5.0")
+	c.Assert(pin, IsNil)
+
+	server.Pins.Add(&Pin{Repository: "github.com/org/repository", Constraint: "v2", Tag: "v2.4.1"})
+	ref, pin = server.resolve(pkg, v)
+	c.Assert(ref.Name().Short(), Equals, "v2.4.1")
+	c.Assert(pin.Tag, Equals, "v2.4.1")
+
+	excluded := v.Exclude(map[string]string{"v2.4.1": "unsigned tag"})
+	ref, pin = server.resolve(pkg, excluded)
+	c.Assert(ref, IsNil)
+	c.Assert(pin.Tag, Equals, "v2.4.1")
+
+	server.Pins.Add(&Pin{Repository: "github.com/org/repository", Constraint: "v2", Tag: "v2.9.9"})
+	ref, _ = server.resolve(pkg, v)
+	c.Assert(ref, IsNil)
+}
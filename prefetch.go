@@ -0,0 +1,219 @@
+package stable
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// Prefetcher refreshes in background the versions and the packfile of the most
+// requested packages, so the clients don't wait for the upstream. The Warmup
+// packages are refreshed at startup.
+type Prefetcher struct {
+	Server *Server
+	// Interval between refreshes of the popular packages, if zero only the
+	// Warmup packages are refreshed.
+	Interval time.Duration
+	// Top is the number of most requested packages refreshed every Interval.
+	Top int
+	// Concurrency is the max number of packages refreshed at the same time.
+	Concurrency int
+	// Warmup are the packages refreshed at startup, by name, eg.:
+	// foo.bar/org/repository.v1
+	Warmup []string
+	// Auth is used to read private repositories, the versions are cached for
+	// the clients with the same credentials. The public repositories are read
+	// anonymously, so the versions are cached for any client.
+	Auth *githttp.BasicAuth
+
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func NewPrefetcher(s *Server, interval time.Duration, top, concurrency int) *Prefetcher {
+	return &Prefetcher{
+		Server:      s,
+		Interval:    interval,
+		Top:         top,
+		Concurrency: concurrency,
+		hits:        make(map[string]int, 0),
+	}
+}
+
+// Track counts a request of the given package.
+func (p *Prefetcher) Track(pkg *Package) {
+	if p == nil || p.Interval <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.hits[pkg.Name]++
+}
+
+// Popular returns the names of the Top most requested packages.
+func (p *Prefetcher) Popular() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var names []string
+	for name := range p.hits {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if p.hits[names[i]] != p.hits[names[j]] {
+			return p.hits[names[i]] > p.hits[names[j]]
+		}
+
+		return names[i] < names[j]
+	})
+
+	if len(names) > p.Top {
+		names = names[:p.Top]
+	}
+
+	return names
+}
+
+// decay halves the hits of every package, so the old requests weight less.
+func (p *Prefetcher) decay() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, hits := range p.hits {
+		if hits/2 == 0 {
+			delete(p.hits, name)
+			continue
+		}
+
+		p.hits[name] = hits / 2
+	}
+}
+
+// Run refreshes the Warmup packages, and the popular ones every Interval,
+// until the context is canceled.
+func (p *Prefetcher) Run(ctx context.Context) {
+	p.Refresh(ctx, p.Warmup...)
+	if p.Interval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.Refresh(ctx, p.Popular()...)
+			p.decay()
+		}
+	}
+}
+
+// Refresh refreshes the given packages, at most Concurrency at the same time,
+// the errors are reported to the stderr.
+func (p *Prefetcher) Refresh(ctx context.Context, names ...string) {
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer func() { <-sem; wg.Done() }()
+			if err := p.refresh(ctx, name); err != nil && !isCanceled(err) {
+				fmt.Fprintf(os.Stderr, "error prefetching %s: %s\n", name, err)
+			}
+		}(name)
+	}
+
+	wg.Wait()
+}
+
+// refresh refreshes the versions and the packfile of the given package, every
+// call to the upstream requires a token of the limiter.
+func (p *Prefetcher) refresh(ctx context.Context, name string) error {
+	s := p.Server
+	pkg, err := s.Package(name)
+	if err != nil {
+		return err
+	}
+
+	f, v, err := p.versions(ctx, pkg)
+	if err != nil {
+		return err
+	}
+
+	versions := s.excludeVersions(pkg, v)
+	ref, _ := s.resolve(pkg, versions)
+	if ref == nil {
+		return ErrVersionNotFound
+	}
+
+	peeled := versions.Peel(ref)
+	if s.Packs == nil || s.Packs.Has(pkg.RepositoryName(), peeled, ref) {
+		return nil
+	}
+
+	if !s.Limiter.AllowUpstream(pkg.Repository.Host) {
+		return ErrRateLimited
+	}
+
+	if _, err := s.Packs.Fetch(ctx, f, ioutil.Discard, peeled, ref); err != nil {
+		if isCanceled(err) {
+			return err
+		}
+
+		return fmt.Errorf("error fetching the packfile of %s: %s", ref.Name().Short(), err)
+	}
+
+	return nil
+}
+
+// versions fetches and caches the versions of the package, anonymously first,
+// so they are cached for the anonymous clients, and with Auth, cached for the
+// clients with the same credentials, if the repository isn't public. The
+// fetcher used is returned.
+func (p *Prefetcher) versions(ctx context.Context, pkg *Package) (*Fetcher, *Versions, error) {
+	s := p.Server
+	if !s.Limiter.AllowUpstream(pkg.Repository.Host) {
+		return nil, nil, ErrRateLimited
+	}
+
+	f := s.newUpstreamFetcher(pkg, nil)
+	since := time.Now()
+	v, err := f.Versions(ctx)
+	username, password := "", ""
+	if err != nil && p.Auth != nil && !isCanceled(err) {
+		if !s.Limiter.AllowUpstream(pkg.Repository.Host) {
+			return nil, nil, ErrRateLimited
+		}
+
+		f = s.newUpstreamFetcher(pkg, p.Auth)
+		since = time.Now()
+		v, err = f.Versions(ctx)
+		username, password = p.Auth.Username, p.Auth.Password
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.prepareVersions(ctx, f, pkg, v)
+	s.Cache.Set(pkg.RepositoryName(), credentialsKey(username, password), v, since)
+	return f, v, nil
+}
@@ -0,0 +1,92 @@
+package stable
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+type PrefetchSuite struct{}
+
+var _ = Suite(&PrefetchSuite{})
+
+func (s *PrefetchSuite) TestPopular(c *C) {
+	p := NewPrefetcher(NewDefaultServer("foo.bar"), time.Minute, 2, 1)
+	for name, hits := range map[string]int{
+		"foo.bar/org/foo.v1": 3,
+		"foo.bar/org/bar.v1": 1,
+		"foo.bar/org/qux.v2": 2,
+	} {
+		for i := 0; i < hits; i++ {
+			p.Track(&Package{Name: name})
+		}
+	}
+
+	c.Assert(p.Popular(), DeepEquals, []string{"foo.bar/org/foo.v1", "foo.bar/org/qux.v2"})
+
+	p.decay()
+	c.Assert(p.hits, DeepEquals, map[string]int{
+		"foo.bar/org/foo.v1": 1,
+		"foo.bar/org/qux.v2": 1,
+	})
+}
+
+func (s *PrefetchSuite) TestTrackDisabled(c *C) {
+	p := NewPrefetcher(NewDefaultServer("foo.bar"), 0, 2, 1)
+	p.Track(&Package{Name: "foo.bar/org/foo.v1"})
+	c.Assert(p.Popular(), HasLen, 0)
+
+	var nilPrefetcher *Prefetcher
+	nilPrefetcher.Track(&Package{Name: "foo.bar/org/foo.v1"})
+}
+
+func (s *PrefetchSuite) TestRefreshInvalidPackage(c *C) {
+	p := NewPrefetcher(NewDefaultServer("foo.bar"), 0, 2, 1)
+	c.Assert(p.refresh(context.Background(), "foo.bar/org"), Equals, ErrPackageNotFound)
+}
+
+func (s *PrefetchSuite) TestRefreshAnonymous(c *C) {
+	upstream := newAdvertisingUpstream(plumbing.Master)
+	defer upstream.Close()
+
+	server := NewDefaultServer("foo.bar")
+	server.Default.Server = upstream.Listener.Addr().String()
+	server.Client = upstream.Client()
+	server.Cache = NewVersionsCache(time.Minute)
+
+	p := NewPrefetcher(server, 0, 2, 1)
+	p.Auth = githttp.NewBasicAuth("token", "")
+	c.Assert(p.refresh(context.Background(), "foo.bar/org/repository.v1"), IsNil)
+
+	// the repository is public, so the versions are cached for any client
+	repository := upstream.Listener.Addr().String() + "/org/repository"
+	_, ok := server.Cache.Get(repository, credentialsKey("", ""))
+	c.Assert(ok, Equals, true)
+	_, ok = server.Cache.Get(repository, credentialsKey("token", ""))
+	c.Assert(ok, Equals, false)
+}
+
+func (s *PrefetchSuite) TestRefreshRateLimited(c *C) {
+	server := NewDefaultServer("foo.bar")
+	server.Limiter = NewRateLimiter(0, 0, 0.001, 1)
+	server.Limiter.AllowUpstream("github.com")
+
+	p := NewPrefetcher(server, 0, 2, 1)
+	c.Assert(p.refresh(context.Background(), "foo.bar/org/repository.v1"), Equals, ErrRateLimited)
+}
+
+func (s *PrefetchSuite) TestConfigWarmup(c *C) {
+	server := NewDefaultServer("foo.bar")
+	config := &Config{Warmup: []string{"foo.bar/org/repository.v1"}}
+	c.Assert(config.Apply(server), ErrorMatches, "warmup requires a prefetcher")
+
+	server.Prefetcher = NewPrefetcher(server, 0, 2, 1)
+	c.Assert(config.Apply(server), IsNil)
+	c.Assert(server.Prefetcher.Warmup, DeepEquals, []string{"foo.bar/org/repository.v1"})
+
+	config = &Config{Warmup: []string{"foo.bar/org"}}
+	c.Assert(config.Apply(server), NotNil)
+}
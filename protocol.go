@@ -0,0 +1,418 @@
+package stable
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+)
+
+var (
+	ErrInvalidPktLine  = errors.New("invalid pkt-line")
+	ErrUnknownCommand  = errors.New("unknown command")
+	ErrInvalidEncoding = errors.New("invalid content encoding")
+	ErrRequestTooLarge = errors.New("request too large")
+)
+
+// DefaultMaxRequestSize is the default max size of a decoded upload-pack
+// request, big enough for a few hundred of thousands of haves.
+const DefaultMaxRequestSize = 10 << 20
+
+// capabilitiesV2 are the capabilities advertised to the protocol v2 clients,
+// shallow and filters are not supported, since every pack is built upstream.
+var capabilitiesV2 = []string{
+	"version 2\n",
+	"agent=go-stable\n",
+	"ls-refs\n",
+	"fetch\n",
+	"object-format=sha1\n",
+}
+
+// sidebandMaxPayload is the max data sent in a side-band-64k packet, the max
+// pkt-line payload minus the band byte.
+const sidebandMaxPayload = pktline.MaxPayloadSize - 1
+
+type pktType int
+
+const (
+	pktFlush pktType = iota
+	pktDelim
+	pktData
+)
+
+// readPktLine reads a pkt-line from r, the delim-pkt (0001) is supported,
+// unlike in pktline.Scanner, since is required by the protocol v2.
+func readPktLine(r io.Reader) (pktType, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	n, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return 0, nil, ErrInvalidPktLine
+	}
+
+	switch {
+	case n == 0:
+		return pktFlush, nil, nil
+	case n == 1:
+		return pktDelim, nil, nil
+	case n < 4 || n > pktline.MaxPayloadSize+4:
+		return 0, nil, ErrInvalidPktLine
+	}
+
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrInvalidPktLine
+		}
+
+		return 0, nil, err
+	}
+
+	return pktData, payload, nil
+}
+
+// uploadPackBody returns the body of a upload-pack request, the gzip encoded
+// bodies are decoded and the decoded size is limited to MaxRequestSize. The
+// chunked transfer encoding is already handled by net/http.
+func (s *Server) uploadPackBody(r *http.Request) (io.Reader, error) {
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, ErrInvalidEncoding
+		}
+
+		body = &gzipReader{r: zr}
+	default:
+		return nil, ErrInvalidEncoding
+	}
+
+	max := s.MaxRequestSize
+	if max <= 0 {
+		max = DefaultMaxRequestSize
+	}
+
+	return &maxSizeReader{r: body, n: max}, nil
+}
+
+// maxSizeReader reads from r, failing with ErrRequestTooLarge after n bytes,
+// unlike io.LimitReader that just returns io.EOF.
+type maxSizeReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	if r.n < 0 {
+		return 0, ErrRequestTooLarge
+	}
+
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+	if r.n < 0 {
+		return 0, ErrRequestTooLarge
+	}
+
+	return n, err
+}
+
+// gzipReader decodes a gzip stream, any decoding error is ErrInvalidEncoding.
+type gzipReader struct {
+	r *gzip.Reader
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = ErrInvalidEncoding
+	}
+
+	return n, err
+}
+
+// validatePktLines reads all the pkt-lines from r, returning ErrInvalidPktLine
+// if the input is malformed.
+func validatePktLines(r io.Reader) error {
+	for {
+		_, _, err := readPktLine(r)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			return ErrInvalidPktLine
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isProtocolV2 returns true if the client asked for the protocol v2.
+func isProtocolV2(r *http.Request) bool {
+	for _, p := range strings.Split(r.Header.Get("Git-Protocol"), ":") {
+		if p == "version=2" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commandV2 is a command request from a protocol v2 client.
+type commandV2 struct {
+	Name         string
+	Capabilities []string
+	Args         []string
+}
+
+func (c *commandV2) hasArg(arg string) bool {
+	for _, a := range c.Args {
+		if a == arg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *commandV2) argsWithPrefix(prefix string) []string {
+	var args []string
+	for _, a := range c.Args {
+		if strings.HasPrefix(a, prefix) {
+			args = append(args, strings.TrimPrefix(a, prefix))
+		}
+	}
+
+	return args
+}
+
+func readCommandV2(r io.Reader) (*commandV2, error) {
+	cmd := &commandV2{}
+	inArgs := false
+	for {
+		t, line, err := readPktLine(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidPktLine
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case pktFlush:
+			if cmd.Name == "" {
+				return nil, ErrUnknownCommand
+			}
+
+			return cmd, nil
+		case pktDelim:
+			inArgs = true
+			continue
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		switch {
+		case inArgs:
+			cmd.Args = append(cmd.Args, string(line))
+		case bytes.HasPrefix(line, []byte("command=")):
+			cmd.Name = string(line[len("command="):])
+		default:
+			cmd.Capabilities = append(cmd.Capabilities, string(line))
+		}
+	}
+}
+
+func (s *Server) encodeCapabilitiesV2(w io.Writer) error {
+	e := pktline.NewEncoder(w)
+	if err := e.EncodeString(capabilitiesV2...); err != nil {
+		return err
+	}
+
+	return e.Flush()
+}
+
+func (s *Server) doUploadPackV2Response(w http.ResponseWriter, r *http.Request) {
+	body, err := s.uploadPackBody(r)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	cmd, err := readCommandV2(body)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	switch cmd.Name {
+	case "ls-refs":
+		s.doLsRefsResponse(w, r, cmd)
+	case "fetch":
+		s.doFetchV2Response(w, r, cmd)
+	default:
+		s.handleError(w, r, ErrUnknownCommand)
+	}
+}
+
+func (s *Server) doLsRefsResponse(w http.ResponseWriter, r *http.Request, cmd *commandV2) {
+	pkg := s.buildPackage(r)
+	fetcher := s.newFetcher(r, pkg)
+	info, head, err := s.getUploadPackInfo(r, fetcher, pkg)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	if err := encodeLsRefs(w, info, head, cmd); err != nil {
+		s.handleError(w, r, err)
+	}
+}
+
+func encodeLsRefs(w io.Writer, info *packp.AdvRefs, head *plumbing.Reference, cmd *commandV2) error {
+	prefixes := cmd.argsWithPrefix("ref-prefix ")
+	symrefs := cmd.hasArg("symrefs")
+	peel := cmd.hasArg("peel")
+
+	e := pktline.NewEncoder(w)
+	if info.Head != nil && hasAnyPrefix(plumbing.HEAD.String(), prefixes) {
+		line := fmt.Sprintf("%s %s", info.Head.String(), plumbing.HEAD)
+		if symrefs {
+			line += " symref-target:" + head.Name().String()
+		}
+
+		if err := e.EncodeString(line + "\n"); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name := range info.References {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if !hasAnyPrefix(name, prefixes) {
+			continue
+		}
+
+		line := fmt.Sprintf("%s %s", info.References[name].String(), name)
+		if peeled, ok := info.Peeled[name]; ok && peel {
+			line += " peeled:" + peeled.String()
+		}
+
+		if err := e.EncodeString(line + "\n"); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+func (s *Server) doFetchV2Response(w http.ResponseWriter, r *http.Request, cmd *commandV2) {
+	pkg := s.buildPackage(r)
+	fetcher := s.newFetcher(r, pkg)
+	ref, peeled, err := s.getVersion(r, fetcher, pkg)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	if !s.Limiter.AllowUpstream(pkg.Repository.Host) {
+		s.handleError(w, r, ErrRateLimited)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+
+	// the haves are ignored and the full pack is always sent, so we are ready
+	// since the beginning of the negotiation
+	e := pktline.NewEncoder(w)
+	if !cmd.hasArg("done") {
+		if err := e.EncodeString("acknowledgments\n", "NAK\n", "ready\n"); err != nil {
+			s.handleError(w, r, err)
+			return
+		}
+
+		if _, err := w.Write([]byte("0001")); err != nil {
+			s.handleError(w, r, err)
+			return
+		}
+	}
+
+	if err := e.EncodeString("packfile\n"); err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	s.Prefetcher.Track(pkg)
+	written, err := s.Packs.Fetch(r.Context(), fetcher, &sidebandWriter{e: e, band: 1}, peeled, ref)
+	s.audit(r, pkg, ref, peeled, written, err)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	e.Flush()
+}
+
+// sidebandWriter writes the data to the given band, as side-band-64k packets.
+type sidebandWriter struct {
+	e    *pktline.Encoder
+	band byte
+}
+
+func (w *sidebandWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > sidebandMaxPayload {
+			chunk = chunk[:sidebandMaxPayload]
+		}
+
+		if err := w.e.Encode(append([]byte{w.band}, chunk...)); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+
+	return false
+}
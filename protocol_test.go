@@ -0,0 +1,192 @@
+package stable
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+)
+
+type ProtocolSuite struct{}
+
+var _ = Suite(&ProtocolSuite{})
+
+func (s *ProtocolSuite) TestReadCommandV2(c *C) {
+	cmd, err := readCommandV2(strings.NewReader("" +
+		"0014command=ls-refs\n" +
+		"0015agent=git/2.20.1\n" +
+		"0001" +
+		"0009peel\n" +
+		"000csymrefs\n" +
+		"001bref-prefix refs/heads/\n" +
+		"0000",
+	))
+
+	c.Assert(err, IsNil)
+	c.Assert(cmd.Name, Equals, "ls-refs")
+	c.Assert(cmd.Capabilities, DeepEquals, []string{"agent=git/2.20.1"})
+	c.Assert(cmd.hasArg("peel"), Equals, true)
+	c.Assert(cmd.hasArg("symrefs"), Equals, true)
+	c.Assert(cmd.argsWithPrefix("ref-prefix "), DeepEquals, []string{"refs/heads/"})
+}
+
+func (s *ProtocolSuite) TestReadCommandV2Invalid(c *C) {
+	_, err := readCommandV2(strings.NewReader("0014command=ls-refs\n"))
+	c.Assert(err, Equals, ErrInvalidPktLine)
+
+	_, err = readCommandV2(strings.NewReader("zzzzcommand=ls-refs\n0000"))
+	c.Assert(err, Equals, ErrInvalidPktLine)
+
+	_, err = readCommandV2(strings.NewReader("0000"))
+	c.Assert(err, Equals, ErrUnknownCommand)
+}
+
+func (s *ProtocolSuite) TestEncodeLsRefs(c *C) {
+	ref := plumbing.NewHashReference("refs/heads/v1", plumbing.NewHash("96f2c336f6aec28963719fb42513b88dfd709d09"))
+	info := (&Server{}).buildGitUploadPackInfo(ref, nil, "master")
+
+	cmd := &commandV2{Name: "ls-refs", Args: []string{"symrefs", "ref-prefix HEAD"}}
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(encodeLsRefs(buf, info, ref, cmd), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"004e96f2c336f6aec28963719fb42513b88dfd709d09 HEAD symref-target:refs/heads/v1\n"+
+		"0000",
+	)
+
+	cmd = &commandV2{Name: "ls-refs", Args: []string{"symrefs"}}
+
+	buf = bytes.NewBuffer(nil)
+	c.Assert(encodeLsRefs(buf, info, ref, cmd), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"004e96f2c336f6aec28963719fb42513b88dfd709d09 HEAD symref-target:refs/heads/v1\n"+
+		"003f96f2c336f6aec28963719fb42513b88dfd709d09 refs/heads/master\n"+
+		"003b96f2c336f6aec28963719fb42513b88dfd709d09 refs/heads/v1\n"+
+		"0000",
+	)
+}
+
+func (s *ProtocolSuite) TestSidebandWriter(c *C) {
+	buf := bytes.NewBuffer(nil)
+	w := &sidebandWriter{e: pktline.NewEncoder(buf), band: 1}
+
+	n, err := w.Write(bytes.Repeat([]byte("a"), sidebandMaxPayload+1))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, sidebandMaxPayload+1)
+	c.Assert(buf.Len(), Equals, sidebandMaxPayload+1+2*5)
+	c.Assert(buf.String()[:5], Equals, "fff0\x01")
+	c.Assert(buf.String()[buf.Len()-6:], Equals, "0006\x01a")
+}
+
+func (s *ProtocolSuite) TestDoUploadPackInfoResponseV2(c *C) {
+	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/releases.v1/info/refs", nil)
+	r.Header.Set("Git-Protocol", "version=2")
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	response := w.Result()
+	body, err := ioutil.ReadAll(response.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, ""+
+		"001e# service=git-upload-pack\n"+
+		"0000"+
+		"000eversion 2\n"+
+		"0014agent=go-stable\n"+
+		"000cls-refs\n"+
+		"000afetch\n"+
+		"0017object-format=sha1\n"+
+		"0000",
+	)
+}
+
+func (s *ProtocolSuite) TestDoUploadPackResponseV2LsRefs(c *C) {
+	body := strings.NewReader("0014command=ls-refs\n0001000csymrefs\n0014ref-prefix HEAD\n001bref-prefix refs/heads/\n0000")
+	r, _ := http.NewRequest("POST", "http://foo.bar/git-fixtures/releases.v1/git-upload-pack", body)
+	r.Header.Set("Git-Protocol", "version=2")
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Equals, ""+
+		"004e96f2c336f6aec28963719fb42513b88dfd709d09 HEAD symref-target:refs/heads/v1\n"+
+		"003f96f2c336f6aec28963719fb42513b88dfd709d09 refs/heads/master\n"+
+		"003b96f2c336f6aec28963719fb42513b88dfd709d09 refs/heads/v1\n"+
+		"0000",
+	)
+}
+
+func (s *ProtocolSuite) TestDoUploadPackResponseV2Invalid(c *C) {
+	body := strings.NewReader("0014command=ls-refs\n")
+	r, _ := http.NewRequest("POST", "http://foo.bar/git-fixtures/releases.v1/git-upload-pack", body)
+	r.Header.Set("Git-Protocol", "version=2")
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
+
+func (s *ProtocolSuite) TestUploadPackBodyGzip(c *C) {
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	zw.Write([]byte("0032want 96f2c336f6aec28963719fb42513b88dfd709d09\n00000009done\n"))
+	zw.Close()
+
+	r, _ := http.NewRequest("POST", "http://foo.bar/", buf)
+	r.Header.Set("Content-Encoding", "gzip")
+
+	body, err := (&Server{}).uploadPackBody(r)
+	c.Assert(err, IsNil)
+	c.Assert(validatePktLines(body), IsNil)
+}
+
+func (s *ProtocolSuite) TestUploadPackBodyInvalidGzip(c *C) {
+	r, _ := http.NewRequest("POST", "http://foo.bar/", strings.NewReader("0000"))
+	r.Header.Set("Content-Encoding", "gzip")
+
+	_, err := (&Server{}).uploadPackBody(r)
+	c.Assert(err, Equals, ErrInvalidEncoding)
+}
+
+func (s *ProtocolSuite) TestUploadPackBodyMaxSize(c *C) {
+	r, _ := http.NewRequest("POST", "http://foo.bar/", strings.NewReader("0009done\n0000"))
+
+	body, err := (&Server{MaxRequestSize: 8}).uploadPackBody(r)
+	c.Assert(err, IsNil)
+	c.Assert(validatePktLines(body), Equals, ErrRequestTooLarge)
+}
+
+func (s *ProtocolSuite) TestValidatePktLines(c *C) {
+	c.Assert(validatePktLines(strings.NewReader("")), IsNil)
+	c.Assert(validatePktLines(strings.NewReader("0009done\n0000")), IsNil)
+	c.Assert(validatePktLines(strings.NewReader("0009don")), Equals, ErrInvalidPktLine)
+	c.Assert(validatePktLines(strings.NewReader("00")), Equals, ErrInvalidPktLine)
+	c.Assert(validatePktLines(strings.NewReader("0003")), Equals, ErrInvalidPktLine)
+	c.Assert(validatePktLines(strings.NewReader("foo bar")), Equals, ErrInvalidPktLine)
+}
+
+func (s *ProtocolSuite) TestDoUploadPackResponseInvalid(c *C) {
+	body := strings.NewReader("foo bar")
+	r, _ := http.NewRequest("POST", "http://foo.bar/git-fixtures/releases.v1/git-upload-pack", body)
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
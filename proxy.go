@@ -1,13 +1,19 @@
 package stable
 
 import (
+	"context"
+	"crypto/sha1"
 	"errors"
 	"fmt"
+	"html"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"gopkg.in/src-d/go-git.v4/plumbing"
@@ -19,6 +25,7 @@ import (
 
 var (
 	ErrVersionNotFound = errors.New("version not found")
+	ErrPackageNotFound = errors.New("package not found")
 )
 
 const (
@@ -26,6 +33,7 @@ const (
 	OrganizationKey = "org"
 	RepositoryKey   = "repository"
 	ConstraintKey   = "version"
+	SubpackageKey   = "subpkg"
 )
 
 func (s *Server) doRootRedirect(w http.ResponseWriter, r *http.Request) {
@@ -46,52 +54,227 @@ func (s *Server) doPackageRedirect(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) doMetaImportResponse(w http.ResponseWriter, r *http.Request) {
 	pkg := s.buildPackage(r)
+	if !s.checkMetaImport(w, r, pkg) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(w, metaImportTemplate, pkg.Name)
+	fmt.Fprintf(w, metaImportTemplate, pkg.Name, s.buildPinHTML(pkg)+s.buildRetractionsHTML(pkg))
+}
+
+func (s *Server) buildPinHTML(pkg *Package) string {
+	pin := s.Pins.Get(pkg.RepositoryName(), pkg.Constrain)
+	if pin == nil {
+		return ""
+	}
+
+	output := fmt.Sprintf(
+		"\n\t\t\t<h2>Pinned version</h2>\n\t\t\t<p>%s is pinned to %s",
+		html.EscapeString(pin.Constraint), html.EscapeString(pin.Tag),
+	)
+
+	if pin.Reason != "" {
+		output += ": " + html.EscapeString(pin.Reason)
+	}
+
+	return output + "</p>\n\t\t"
+}
+
+func (s *Server) buildRetractionsHTML(pkg *Package) string {
+	retractions := s.Retractions.Repository(pkg.RepositoryName())
+	if len(retractions) == 0 {
+		return ""
+	}
+
+	output := "\n\t\t\t<h2>Retracted versions</h2>\n\t\t\t<ul>\n"
+	for _, r := range retractions {
+		output += fmt.Sprintf(
+			"\t\t\t\t<li>%s: %s</li>\n",
+			html.EscapeString(r.Tag), html.EscapeString(r.Reason),
+		)
+	}
+
+	return output + "\t\t\t</ul>\n\t\t"
 }
 
 func (s *Server) doUploadPackInfoResponse(w http.ResponseWriter, r *http.Request) {
 	pkg := s.buildPackage(r)
-	fetcher := NewFetcher(pkg, getAuth(r))
-	ref, err := s.getVersion(fetcher, pkg)
+	fetcher := s.newFetcher(r, pkg)
+	info, _, err := s.getUploadPackInfo(r, fetcher, pkg)
 	if err != nil {
 		s.handleError(w, r, err)
 		return
 	}
 
-	ref = s.mutateTagToBranch(ref, pkg.Constrain)
-	info := s.buildGitUploadPackInfo(ref)
-
 	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
 
 	e := pktline.NewEncoder(w)
 	e.Encode([]byte("# service=git-upload-pack\n"))
 	e.Flush()
 
+	// the version is resolved anyway, to request the auth as soon as possible
+	if isProtocolV2(r) {
+		s.encodeCapabilitiesV2(w)
+		return
+	}
+
 	info.Encode(w)
 }
 
-func (s *Server) getVersion(f *Fetcher, pkg *Package) (*plumbing.Reference, error) {
-	versions, err := f.Versions()
+// getUploadPackInfo returns the advertisement of the resolved version and the
+// branch used as HEAD.
+func (s *Server) getUploadPackInfo(r *http.Request, f *Fetcher, pkg *Package) (*packp.AdvRefs, *plumbing.Reference, error) {
+	versions, err := s.getVersions(r, f, pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ref, peeled, err := s.resolveVersion(pkg, versions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	branch := s.mutateTagToBranch(peeled, pkg.Constrain)
+	return s.buildGitUploadPackInfo(branch, ref, versions.DefaultBranch()), branch, nil
+}
+
+// getVersion returns the reference matching the package constraint and the
+// same reference peeled, pointing to a commit.
+func (s *Server) getVersion(r *http.Request, f *Fetcher, pkg *Package) (ref, peeled *plumbing.Reference, err error) {
+	versions, err := s.getVersions(r, f, pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.resolveVersion(pkg, versions)
+}
+
+// resolveVersion is like resolve, but the reference is returned peeled too,
+// ErrVersionNotFound is returned if no version matches.
+func (s *Server) resolveVersion(pkg *Package, versions *Versions) (ref, peeled *plumbing.Reference, err error) {
+	ref, _ = s.resolve(pkg, versions)
+	if ref == nil {
+		return nil, nil, ErrVersionNotFound
+	}
+
+	return ref, versions.Peel(ref), nil
+}
+
+// resolve returns the reference matching the package constraint, the pin of
+// the constraint, if any, takes precedence over the best match, unless the
+// pinned tag is excluded, eg.: retracted or unsigned, then nothing is resolved.
+// The channels are resolved following its rule.
+func (s *Server) resolve(pkg *Package, v *Versions) (*plumbing.Reference, *Pin) {
+	if pin := s.Pins.Get(pkg.RepositoryName(), pkg.Constrain); pin != nil {
+		if v.IsExcluded(pin.Tag) {
+			return nil, pin
+		}
+
+		return v.References[pin.Tag], pin
+	}
+
+	if c, ok := s.Channels[pkg.Constrain]; ok {
+		return v.Channel(c), nil
+	}
+
+	return v.BestMatch(pkg.Constrain), nil
+}
+
+// getVersions coalesces the identical concurrent calls to the upstream, the
+// credentials are part of the key so private results are never shared.
+func (s *Server) getVersions(r *http.Request, f *Fetcher, pkg *Package) (*Versions, error) {
+	ctx := r.Context()
+	repository, credentials := pkg.RepositoryName(), getCredentialsKey(r)
+	if v, ok := s.Cache.Get(repository, credentials); ok {
+		return s.excludeVersions(pkg, v), nil
+	}
+
+	key := pkg.Repository.String() + "@" + credentials
+	for {
+		v, err, shared := s.flight.Do(key, func() (interface{}, error) {
+			if !s.Limiter.AllowUpstream(pkg.Repository.Host) {
+				return nil, ErrRateLimited
+			}
+
+			since := time.Now()
+			v, err := f.Versions(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			s.prepareVersions(ctx, f, pkg, v)
+			s.Cache.Set(repository, credentials, v, since)
+			return v, nil
+		})
+
+		// the request that started the shared call was canceled, not this one
+		if shared && isCanceled(err) && ctx.Err() == nil {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.excludeVersions(pkg, v.(*Versions)), nil
+	}
+}
+
+// Versions returns the Versions of the package advertised by the upstream,
+// with the exclusion policies applied, as the requests are resolved. Unlike
+// the requests, the cache and the rate limit of the upstream aren't used.
+func (s *Server) Versions(ctx context.Context, pkg *Package, auth transport.AuthMethod) (*Versions, error) {
+	f := s.newUpstreamFetcher(pkg, auth)
+	v, err := f.Versions(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	v := versions.BestMatch(pkg.Constrain)
-	if v == nil {
-		return nil, ErrVersionNotFound
+	s.prepareVersions(ctx, f, pkg, v)
+	return s.excludeVersions(pkg, v), nil
+}
+
+// Resolve returns the reference the package resolves to, following the pins
+// and the channels, and the pin of the constraint, if any, see Versions.
+func (s *Server) Resolve(pkg *Package, v *Versions) (*plumbing.Reference, *Pin) {
+	return s.resolve(pkg, v)
+}
+
+// prepareVersions fetches from the upstream the data required by the exclusion
+// policies, it's called once per call to the upstream, so the concurrent
+// requests share it. The errors are reported to the stderr.
+func (s *Server) prepareVersions(ctx context.Context, f *Fetcher, pkg *Package, v *Versions) {
+	if err := s.Signatures.Prepare(ctx, f, s.Limiter, pkg, v); err != nil {
+		fmt.Fprintf(os.Stderr, "error fetching tags of %s: %s\n", pkg.RepositoryName(), err)
+	}
+
+	// the newest tags are selected after the signature policy, as Exclude does
+	v = s.Signatures.Exclude(v)
+	if err := s.GoModRetractions.Prepare(ctx, f, s.Limiter, pkg, v); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading go.mod retractions of %s: %s\n", pkg.RepositoryName(), err)
 	}
+}
 
-	return v, nil
+// excludeVersions applies the exclusion policies to the Versions advertised by
+// the upstream, the given Versions are not modified.
+func (s *Server) excludeVersions(pkg *Package, v *Versions) *Versions {
+	v = s.Signatures.Exclude(v)
+	v = s.GoModRetractions.Exclude(pkg, v)
+	return s.Retractions.Exclude(pkg, v)
 }
 
-// we mutate the tag into a branch to avoid detached branches
+// we mutate the tag into a branch to avoid detached branches, the reference
+// should be already peeled, a branch can't point to an annotated tag
 func (s *Server) mutateTagToBranch(ref *plumbing.Reference, constraint string) *plumbing.Reference {
 	branch := plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", constraint))
 	return plumbing.NewHashReference(branch, ref.Hash())
 }
 
-func (s *Server) buildGitUploadPackInfo(ref *plumbing.Reference) *packp.AdvRefs {
+// buildGitUploadPackInfo returns the advertisement of the given branch, if the
+// branch comes from a tag, the tag is advertised too, so the exact version is
+// available in the checkout, eg.: git describe. The default branch of the
+// upstream is advertised pointing to the same commit.
+func (s *Server) buildGitUploadPackInfo(ref, tag *plumbing.Reference, defaultBranch string) *packp.AdvRefs {
 	h := ref.Hash()
 
 	info := packp.NewAdvRefs()
@@ -100,18 +283,34 @@ func (s *Server) buildGitUploadPackInfo(ref *plumbing.Reference) *packp.AdvRefs
 	info.AddReference(plumbing.NewSymbolicReference(plumbing.HEAD, ref.Name()))
 	info.Capabilities.Set("symref", "HEAD:"+ref.Name().String())
 
+	if tag != nil && tag.IsTag() {
+		info.AddReference(tag)
+		if tag.Hash() != h {
+			info.Peeled[tag.Name().String()] = h
+		}
+	}
+
 	// temporal fix due to https://github.com/golang/gddo/issues/464
-	info.AddReference(plumbing.NewHashReference("refs/heads/master", ref.Hash()))
+	branch := plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", defaultBranch))
+	info.AddReference(plumbing.NewHashReference(branch, ref.Hash()))
 	return info
 }
 
 func (s *Server) buildPackage(r *http.Request) *Package {
-	params := mux.Vars(r)
+	return s.buildPackageFromVars(mux.Vars(r))
+}
+
+func (s *Server) buildPackageFromVars(params map[string]string) *Package {
 	server := getOrDefault(params, ServerKey, s.Default.Server)
 	organization := getOrDefault(params, OrganizationKey, s.Default.Organization)
 	repository := getOrDefault(params, RepositoryKey, s.Default.Repository)
 
-	name, err := s.r.Get("base").URL(
+	route := "base"
+	if _, ok := s.Channels[params[ConstraintKey]]; ok {
+		route = "channel"
+	}
+
+	name, err := s.r.Get(route).URL(
 		"server", server,
 		"org", organization,
 		"repository", removeSubpackage(repository),
@@ -122,11 +321,15 @@ func (s *Server) buildPackage(r *http.Request) *Package {
 		panic(fmt.Sprintf("unreachable: %s [%s]", err.Error(), params))
 	}
 
-	return &Package{
+	pkg := &Package{
 		Name:       path.Join(s.Host, name.String()),
 		Repository: s.buildEndpoint(server, organization, repository),
 		Constrain:  params[ConstraintKey],
+		Subpackage: buildSubpackage(repository, params[SubpackageKey]),
 	}
+
+	pkg.Config = s.Repositories.Match(pkg.RepositoryName())
+	return pkg
 }
 
 func (s *Server) buildEndpoint(server, orgnization, repository string) transport.Endpoint {
@@ -142,14 +345,37 @@ func (s *Server) buildEndpoint(server, orgnization, repository string) transport
 }
 
 func (s *Server) doUploadPackResponse(w http.ResponseWriter, r *http.Request) {
+	if isProtocolV2(r) {
+		s.doUploadPackV2Response(w, r)
+		return
+	}
+
+	body, err := s.uploadPackBody(r)
+	if err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
+	// the wants and haves are ignored, since the resolved version is always
+	// sent, but the request should be a valid one
+	if err := validatePktLines(body); err != nil {
+		s.handleError(w, r, err)
+		return
+	}
+
 	pkg := s.buildPackage(r)
-	fetcher := NewFetcher(pkg, getAuth(r))
-	ref, err := s.getVersion(fetcher, pkg)
+	fetcher := s.newFetcher(r, pkg)
+	ref, peeled, err := s.getVersion(r, fetcher, pkg)
 	if err != nil {
 		s.handleError(w, r, err)
 		return
 	}
 
+	if !s.Limiter.AllowUpstream(pkg.Repository.Host) {
+		s.handleError(w, r, ErrRateLimited)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
 
 	pkt := pktline.NewEncoder(w)
@@ -158,7 +384,9 @@ func (s *Server) doUploadPackResponse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = fetcher.Fetch(w, ref)
+	s.Prefetcher.Track(pkg)
+	written, err := s.Packs.Fetch(r.Context(), fetcher, w, peeled, ref)
+	s.audit(r, pkg, ref, peeled, written, err)
 	if err != nil {
 		s.handleError(w, r, err)
 		return
@@ -173,6 +401,20 @@ func (s *Server) handleError(w http.ResponseWriter, r *http.Request, err error)
 	case ErrVersionNotFound:
 		w.WriteHeader(http.StatusNotFound)
 		return
+	case ErrRateLimited:
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	case ErrInvalidPktLine, ErrUnknownCommand, ErrInvalidEncoding:
+		http.Error(w, "protocol error: "+err.Error(), http.StatusBadRequest)
+		return
+	case ErrRequestTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if isCanceled(err) {
+		// the client is gone, nobody is waiting for a response
+		return
 	}
 
 	w.WriteHeader(http.StatusInternalServerError)
@@ -189,6 +431,40 @@ func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusUnauthorized)
 }
 
+func (s *Server) newFetcher(r *http.Request, pkg *Package) *Fetcher {
+	return s.newUpstreamFetcher(pkg, getAuth(r))
+}
+
+func (s *Server) newUpstreamFetcher(pkg *Package, auth transport.AuthMethod) *Fetcher {
+	c := s.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	return NewFetcherWithClient(c, pkg, auth)
+}
+
+func (s *Server) doSumDBResponse(w http.ResponseWriter, r *http.Request) {
+	if s.SumDB == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.SumDB.ServeHTTP(w, r)
+}
+
+// limitClient rejects the requests of the clients exceeding its rate limit.
+func (s *Server) limitClient(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Limiter.AllowClient(s.clientKey(r)) {
+			s.handleError(w, r, ErrRateLimited)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
 func getOrDefault(m map[string]string, key, def string) string {
 	if v, ok := m[key]; ok {
 		return v
@@ -203,15 +479,69 @@ func getAuth(r *http.Request) *githttp.BasicAuth {
 	return githttp.NewBasicAuth(username, password)
 }
 
+// getCredentialsKey returns a digest of the basic auth credentials, to be used
+// as key without keeping the credentials in memory.
+func getCredentialsKey(r *http.Request) string {
+	username, password, _ := r.BasicAuth()
+
+	return credentialsKey(username, password)
+}
+
+func credentialsKey(username, password string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(username+":"+password)))
+}
+
+// isCanceled returns true if the error was caused by a canceled context or by
+// its deadline. The errors returned by go-git and net/http are unwrapped first.
+func isCanceled(err error) bool {
+	for err != nil {
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return true
+		}
+
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *plumbing.UnexpectedError:
+			err = e.Err
+		case *plumbing.PermanentError:
+			err = e.Err
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
 func removeSubpackage(pkg string) string {
 	p := strings.Split(pkg, "/")
 	return p[0]
 }
 
+// buildSubpackage returns the path of the subpackage, from the repository
+// variable, eg.: repository/subpkg, and the subpkg variable.
+func buildSubpackage(repository, subpkg string) string {
+	var dirs []string
+	if p := strings.SplitN(repository, "/", 2); len(p) == 2 && p[1] != "" {
+		dirs = append(dirs, p[1])
+	}
+
+	if subpkg != "" {
+		dirs = append(dirs, subpkg)
+	}
+
+	return strings.Join(dirs, "/")
+}
+
 var metaImportTemplate = "" +
 	`<html>
 		<head>
 			<meta name="go-import" content="%s git https://%#[1]s">
 		</head>
-		<body></body>
+		<body>%[2]s</body>
 	</html>`
@@ -1,10 +1,19 @@
 package stable
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 )
 
 import "net/http/httptest"
@@ -52,11 +61,73 @@ func (s *ProxySuite) doTestDoMetaImportResponse(c *C, r *http.Request) {
 	c.Assert(response.Header.Get("Content-Type"), Equals, "text/html")
 }
 
+func (s *ProxySuite) TestDoMetaImportResponseRetractions(c *C) {
+	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/releases.v1?go-get=1", nil)
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.Retractions.Add(&Retraction{
+		Repository: "github.com/git-fixtures/releases",
+		Tag:        "v1.0.0",
+		Reason:     "broken <build>",
+	})
+
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	c.Assert(w.Body.String(), Equals, ""+
+		"<html>\n"+
+		"\t\t<head>\n"+
+		"\t\t\t<meta name=\"go-import\" content=\"foo.bar/git-fixtures/releases.v1 git https://foo.bar/git-fixtures/releases.v1\">\n"+
+		"\t\t</head>\n"+
+		"\t\t<body>\n"+
+		"\t\t\t<h2>Retracted versions</h2>\n"+
+		"\t\t\t<ul>\n"+
+		"\t\t\t\t<li>v1.0.0: broken &lt;build&gt;</li>\n"+
+		"\t\t\t</ul>\n"+
+		"\t\t</body>\n"+
+		"\t</html>",
+	)
+}
+
+func (s *ProxySuite) TestDoMetaImportResponsePin(c *C) {
+	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/releases.v1?go-get=1", nil)
+	w := httptest.NewRecorder()
+
+	server := NewDefaultServer("foo.bar")
+	server.Pins.Add(&Pin{
+		Repository: "github.com/git-fixtures/releases",
+		Constraint: "v1",
+		Tag:        "v1.0.0",
+		Reason:     "incident",
+	})
+
+	server.buildRouter()
+	server.Handler.ServeHTTP(w, r)
+
+	c.Assert(w.Body.String(), Equals, ""+
+		"<html>\n"+
+		"\t\t<head>\n"+
+		"\t\t\t<meta name=\"go-import\" content=\"foo.bar/git-fixtures/releases.v1 git https://foo.bar/git-fixtures/releases.v1\">\n"+
+		"\t\t</head>\n"+
+		"\t\t<body>\n"+
+		"\t\t\t<h2>Pinned version</h2>\n"+
+		"\t\t\t<p>v1 is pinned to v1.0.0: incident</p>\n"+
+		"\t\t</body>\n"+
+		"\t</html>",
+	)
+}
+
 func (s *ProxySuite) TestDoUploadPackInfoResponse(c *C) {
-	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/releases.v1/info/refs", nil)
+	upstream := newAdvertisingUpstream(plumbing.Master)
+	defer upstream.Close()
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/org/repository.v1/info/refs", nil)
 	w := httptest.NewRecorder()
 
 	server := NewDefaultServer("foo.bar")
+	server.Default.Server = upstream.Listener.Addr().String()
+	server.Client = upstream.Client()
 	server.buildRouter()
 	server.Handler.ServeHTTP(w, r)
 
@@ -65,9 +136,11 @@ func (s *ProxySuite) TestDoUploadPackInfoResponse(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(string(body), Equals, ""+
 		"001e# service=git-upload-pack\n"+
-		"0000006696f2c336f6aec28963719fb42513b88dfd709d09 HEAD\x00symref=HEAD:refs/heads/v1 symref=HEAD:refs/heads/v1\n"+
-		"003f96f2c336f6aec28963719fb42513b88dfd709d09 refs/heads/master\n"+
-		"003b96f2c336f6aec28963719fb42513b88dfd709d09 refs/heads/v1\n"+
+		"00000066"+fixtureCommit+" HEAD\x00symref=HEAD:refs/heads/v1 symref=HEAD:refs/heads/v1\n"+
+		"003f"+fixtureCommit+" refs/heads/master\n"+
+		"003b"+fixtureCommit+" refs/heads/v1\n"+
+		"003e"+fixtureTag+" refs/tags/v1.0.0\n"+
+		"0041"+fixtureCommit+" refs/tags/v1.0.0^{}\n"+
 		"0000",
 	)
 
@@ -75,6 +148,95 @@ func (s *ProxySuite) TestDoUploadPackInfoResponse(c *C) {
 	c.Assert(response.Header.Get("Content-Type"), Equals, "application/x-git-upload-pack-advertisement")
 }
 
+func (s *ProxySuite) TestDoUploadPackInfoResponseDefaultBranch(c *C) {
+	upstream := newAdvertisingUpstream("refs/heads/main")
+	defer upstream.Close()
+
+	server := NewDefaultServer("foo.bar")
+	server.Default.Server = upstream.Listener.Addr().String()
+	server.Client = upstream.Client()
+	server.buildRouter()
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/org/repository.v1/info/refs", nil)
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Matches, "(?s).*003d"+fixtureCommit+" refs/heads/main\n.*")
+	c.Assert(strings.Contains(w.Body.String(), "refs/heads/master"), Equals, false)
+
+	// the default branch of the config takes precedence over the upstream HEAD
+	server = NewDefaultServer("foo.bar")
+	server.Default.Server = upstream.Listener.Addr().String()
+	server.Client = upstream.Client()
+	server.Repositories = RepositoryConfigs{{
+		Repository:    upstream.Listener.Addr().String() + "/org/repository",
+		DefaultBranch: "develop",
+	}}
+	server.buildRouter()
+
+	w = httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Matches, "(?s).*0040"+fixtureCommit+" refs/heads/develop\n.*")
+	c.Assert(strings.Contains(w.Body.String(), "refs/heads/main"), Equals, false)
+}
+
+const (
+	fixtureCommit = "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"
+	fixtureTag    = "b029517f6300c2da0f4b651b8642506cd6aaf45d"
+)
+
+// newAdvertisingUpstream returns a git server advertising the given branch, as
+// HEAD, and the annotated tag v1.0.0, both pointing to fixtureCommit.
+func newAdvertisingUpstream(head plumbing.ReferenceName) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commit := plumbing.NewHash(fixtureCommit)
+
+		info := packp.NewAdvRefs()
+		info.Head = &commit
+		info.AddReference(plumbing.NewSymbolicReference(plumbing.HEAD, head))
+		info.AddReference(plumbing.NewHashReference(head, commit))
+		info.AddReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash(fixtureTag)))
+		info.Peeled["refs/tags/v1.0.0"] = commit
+
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		e := pktline.NewEncoder(w)
+		e.Encode([]byte("# service=git-upload-pack\n"))
+		e.Flush()
+		info.Encode(w)
+	}))
+}
+
+func (s *ProxySuite) TestBuildGitUploadPackInfoAnnotatedTag(c *C) {
+	commit := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	tag := plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("b029517f6300c2da0f4b651b8642506cd6aaf45d"))
+
+	server := NewDefaultServer("foo.bar")
+	branch := server.mutateTagToBranch(plumbing.NewHashReference(tag.Name(), commit), "v1")
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(server.buildGitUploadPackInfo(branch, tag, "master").Encode(buf), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"00666ecf0ef2c2dffb796033e5a02219af86ec6584e5 HEAD\x00symref=HEAD:refs/heads/v1 symref=HEAD:refs/heads/v1\n"+
+		"003f6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n"+
+		"003b6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/v1\n"+
+		"003eb029517f6300c2da0f4b651b8642506cd6aaf45d refs/tags/v1.0.0\n"+
+		"00416ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/tags/v1.0.0^{}\n"+
+		"0000",
+	)
+}
+
+func (s *ProxySuite) TestBuildGitUploadPackInfoLightweightTag(c *C) {
+	tag := plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	server := NewDefaultServer("foo.bar")
+	branch := server.mutateTagToBranch(tag, "v1")
+
+	info := server.buildGitUploadPackInfo(branch, tag, "master")
+	c.Assert(info.References["refs/tags/v1.0.0"], Equals, tag.Hash())
+	c.Assert(info.Peeled, HasLen, 0)
+}
+
 func (s *ProxySuite) TestDoUploadPackInfoResponsePrivate(c *C) {
 	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/private.v1/info/refs", nil)
 	w := httptest.NewRecorder()
@@ -174,3 +336,20 @@ func (s *ProxySuite) TestDoPackageRedirectSubSubpackage(c *C) {
 	c.Assert(response.StatusCode, Equals, http.StatusFound)
 	c.Assert(response.Header.Get("Location"), Equals, "https://github.com/org/repository")
 }
+
+func (s *ProxySuite) TestIsCanceled(c *C) {
+	c.Assert(isCanceled(context.Canceled), Equals, true)
+	c.Assert(isCanceled(context.DeadlineExceeded), Equals, true)
+	c.Assert(isCanceled(&url.Error{Op: "Get", Err: context.Canceled}), Equals, true)
+
+	err := plumbing.NewUnexpectedError(&url.Error{
+		Op:  "Get",
+		Err: &net.OpError{Op: "dial", Err: context.DeadlineExceeded},
+	})
+	c.Assert(isCanceled(err), Equals, true)
+	c.Assert(isCanceled(plumbing.NewPermanentError(context.Canceled)), Equals, true)
+
+	c.Assert(isCanceled(nil), Equals, false)
+	c.Assert(isCanceled(errors.New("foo")), Equals, false)
+	c.Assert(isCanceled(plumbing.NewUnexpectedError(errors.New("foo"))), Equals, false)
+}
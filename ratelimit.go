@@ -0,0 +1,187 @@
+package stable
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// RateLimiter limits the requests per client and the calls made to each
+// upstream git server, a zero rate means unlimited.
+type RateLimiter struct {
+	ClientRate    rate.Limit
+	ClientBurst   int
+	UpstreamRate  rate.Limit
+	UpstreamBurst int
+
+	mu        sync.Mutex
+	clients   map[string]*limiterEntry
+	upstreams map[string]*limiterEntry
+	evicted   time.Time
+}
+
+type limiterEntry struct {
+	limiter *rate.Limiter
+	used    time.Time
+}
+
+func NewRateLimiter(client rate.Limit, clientBurst int, upstream rate.Limit, upstreamBurst int) *RateLimiter {
+	return &RateLimiter{
+		ClientRate:    client,
+		ClientBurst:   clientBurst,
+		UpstreamRate:  upstream,
+		UpstreamBurst: upstreamBurst,
+		clients:       make(map[string]*limiterEntry, 0),
+		upstreams:     make(map[string]*limiterEntry, 0),
+	}
+}
+
+// AllowClient reports if the client identified by key can do a new request.
+func (l *RateLimiter) AllowClient(key string) bool {
+	if l == nil || l.ClientRate == 0 {
+		return true
+	}
+
+	return l.get(l.clients, key, l.ClientRate, l.ClientBurst).Allow()
+}
+
+// AllowUpstream reports if a new call can be done to the given upstream host.
+func (l *RateLimiter) AllowUpstream(host string) bool {
+	if l == nil || l.UpstreamRate == 0 {
+		return true
+	}
+
+	return l.get(l.upstreams, host, l.UpstreamRate, l.UpstreamBurst).Allow()
+}
+
+func (l *RateLimiter) get(m map[string]*limiterEntry, key string, r rate.Limit, b int) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := m[key]; ok {
+		entry.used = now
+		return entry.limiter
+	}
+
+	l.evict(now)
+	if b < 1 {
+		b = 1
+	}
+
+	m[key] = &limiterEntry{limiter: rate.NewLimiter(r, b), used: now}
+	return m[key].limiter
+}
+
+// evict removes the limiters idle long enough to have its burst refilled, so
+// they are equivalent to a new one, at most once per minute, l.mu should be
+// held.
+func (l *RateLimiter) evict(now time.Time) {
+	if now.Sub(l.evicted) < time.Minute {
+		return
+	}
+
+	l.evicted = now
+	evictIdle(l.clients, now, l.ClientRate, l.ClientBurst)
+	evictIdle(l.upstreams, now, l.UpstreamRate, l.UpstreamBurst)
+}
+
+func evictIdle(m map[string]*limiterEntry, now time.Time, r rate.Limit, b int) {
+	if r <= 0 {
+		return
+	}
+
+	if b < 1 {
+		b = 1
+	}
+
+	refill := time.Duration(float64(b) / float64(r) * float64(time.Second))
+	for key, entry := range m {
+		if now.Sub(entry.used) > refill {
+			delete(m, key)
+		}
+	}
+}
+
+// clientKey returns the identity of the client used for the rate limit, the
+// address of the client, see remoteAddr. The basic auth user isn't used, since
+// it isn't verified until the upstream is called.
+func (s *Server) clientKey(r *http.Request) string {
+	return "addr:" + s.remoteAddr(r)
+}
+
+// remoteAddr returns the address of the client, without port. The X-Real-IP
+// header is only used when the connection comes from one of TrustedProxies.
+func (s *Server) remoteAddr(r *http.Request) string {
+	remoteAddr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+
+	realIP := r.Header.Get("X-Real-IP")
+	if realIP == "" || !s.isTrustedProxy(remoteAddr) {
+		return remoteAddr
+	}
+
+	if host, _, err := net.SplitHostPort(realIP); err == nil {
+		realIP = host
+	}
+
+	return realIP
+}
+
+func (s *Server) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range s.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTrustedProxies parses the given IP addresses or CIDR ranges, eg.:
+// 10.0.0.1 or 10.0.0.0/8
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var output []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %q", p)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			output = append(output, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %q", p)
+		}
+
+		output = append(output, n)
+	}
+
+	return output, nil
+}
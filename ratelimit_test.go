@@ -0,0 +1,120 @@
+package stable
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RateLimiterSuite struct{}
+
+var _ = Suite(&RateLimiterSuite{})
+
+func (s *RateLimiterSuite) TestAllowClient(c *C) {
+	l := NewRateLimiter(0.001, 2, 0, 0)
+	c.Assert(l.AllowClient("foo"), Equals, true)
+	c.Assert(l.AllowClient("foo"), Equals, true)
+	c.Assert(l.AllowClient("foo"), Equals, false)
+	c.Assert(l.AllowClient("bar"), Equals, true)
+}
+
+func (s *RateLimiterSuite) TestAllowUpstream(c *C) {
+	l := NewRateLimiter(0, 0, 0.001, 1)
+	c.Assert(l.AllowUpstream("github.com"), Equals, true)
+	c.Assert(l.AllowUpstream("github.com"), Equals, false)
+	c.Assert(l.AllowUpstream("gitlab.com"), Equals, true)
+	c.Assert(l.AllowClient("foo"), Equals, true)
+}
+
+func (s *RateLimiterSuite) TestNilIsUnlimited(c *C) {
+	var l *RateLimiter
+	c.Assert(l.AllowClient("foo"), Equals, true)
+	c.Assert(l.AllowUpstream("github.com"), Equals, true)
+}
+
+func (s *RateLimiterSuite) TestEvict(c *C) {
+	l := NewRateLimiter(1, 10, 1, 10)
+	c.Assert(l.AllowClient("10.0.0.1"), Equals, true)
+	c.Assert(l.AllowClient("10.0.0.2"), Equals, true)
+	c.Assert(l.AllowUpstream("github.com"), Equals, true)
+
+	// idle longer than the 10s needed to refill the burst
+	l.clients["10.0.0.1"].used = time.Now().Add(-time.Minute)
+	l.upstreams["github.com"].used = time.Now().Add(-time.Minute)
+	l.evicted = time.Time{}
+
+	c.Assert(l.AllowClient("10.0.0.3"), Equals, true)
+	c.Assert(l.clients, HasLen, 2)
+	c.Assert(l.upstreams, HasLen, 0)
+
+	_, ok := l.clients["10.0.0.2"]
+	c.Assert(ok, Equals, true)
+}
+
+func (s *RateLimiterSuite) TestClientKey(c *C) {
+	server := NewDefaultServer("foo.bar")
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/", nil)
+	r.RemoteAddr = "10.0.0.1:4242"
+	c.Assert(server.clientKey(r), Equals, "addr:10.0.0.1")
+
+	r.Header.Set("X-Real-IP", "10.0.0.2")
+	c.Assert(server.clientKey(r), Equals, "addr:10.0.0.1")
+
+	r.SetBasicAuth("token", "")
+	c.Assert(server.clientKey(r), Equals, "addr:10.0.0.1")
+
+	var err error
+	server.TrustedProxies, err = ParseTrustedProxies([]string{"10.0.0.1"})
+	c.Assert(err, IsNil)
+	c.Assert(server.clientKey(r), Equals, "addr:10.0.0.2")
+
+	r.RemoteAddr = "10.0.0.3:4242"
+	c.Assert(server.clientKey(r), Equals, "addr:10.0.0.3")
+}
+
+func (s *RateLimiterSuite) TestParseTrustedProxies(c *C) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16", "::1"})
+	c.Assert(err, IsNil)
+	c.Assert(proxies, HasLen, 3)
+	c.Assert(proxies[0].Contains(net.ParseIP("10.0.0.1")), Equals, true)
+	c.Assert(proxies[0].Contains(net.ParseIP("10.0.0.2")), Equals, false)
+	c.Assert(proxies[1].Contains(net.ParseIP("192.168.1.1")), Equals, true)
+	c.Assert(proxies[2].Contains(net.ParseIP("::1")), Equals, true)
+
+	_, err = ParseTrustedProxies([]string{"foo"})
+	c.Assert(err, NotNil)
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	c.Assert(err, NotNil)
+}
+
+func (s *RateLimiterSuite) TestLimitClient(c *C) {
+	server := NewDefaultServer("foo.bar")
+	server.Limiter = NewRateLimiter(0.001, 1, 0, 0)
+	h := server.limitClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/org/repository.v1/info/refs", nil)
+	r.RemoteAddr = "10.0.0.1:4242"
+
+	w := httptest.NewRecorder()
+	h(w, r)
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+
+	r.SetBasicAuth("random", "x")
+	r.Header.Set("X-Real-IP", "10.0.0.2")
+
+	w = httptest.NewRecorder()
+	h(w, r)
+	c.Assert(w.Code, Equals, http.StatusTooManyRequests)
+
+	r.RemoteAddr = "10.0.0.3:4242"
+	w = httptest.NewRecorder()
+	h(w, r)
+	c.Assert(w.Code, Equals, http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package stable
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidRepositoryConfig = errors.New("invalid repository config, repository is required")
+)
+
+// RepositoryConfig are the settings of the repositories matching Repository,
+// eg.: how the versions are extracted from the tag names.
+type RepositoryConfig struct {
+	// Repository is a repository name without scheme, eg.:
+	// github.com/org/repository, or a prefix ending with a slash matching all
+	// the repositories of an organization, eg.: github.com/org/
+	Repository string `json:"repository"`
+	// TagPattern is a regular expression matching the tags to be resolved, the
+	// version is extracted from the group named version, or the first group,
+	// eg.: ^release-(?P<version>.+)$. The tags not matching are ignored.
+	TagPattern string `json:"tag_pattern,omitempty"`
+	// Scheme is how the versions are resolved, ordered and grouped in majors,
+	// one of SchemeSemVer, the default, SchemeCalVer or SchemeGopkgIn.
+	Scheme string `json:"scheme,omitempty"`
+	// DefaultBranch overrides the branch advertised as HEAD by the upstream,
+	// resolved as v0 and by the channels following a branch, eg.: develop
+	DefaultBranch string `json:"default_branch,omitempty"`
+
+	tagRegexp *regexp.Regexp
+}
+
+func (c *RepositoryConfig) validate() error {
+	if c.Repository == "" {
+		return ErrInvalidRepositoryConfig
+	}
+
+	switch c.Scheme {
+	case "", SchemeSemVer, SchemeCalVer, SchemeGopkgIn:
+	default:
+		return fmt.Errorf("invalid scheme of %s: %q", c.Repository, c.Scheme)
+	}
+
+	if c.TagPattern == "" {
+		return nil
+	}
+
+	var err error
+	c.tagRegexp, err = regexp.Compile(c.TagPattern)
+	if err != nil {
+		return fmt.Errorf("invalid tag pattern of %s: %s", c.Repository, err)
+	}
+
+	return nil
+}
+
+// matches returns true if the config applies to the given repository.
+func (c *RepositoryConfig) matches(repository string) bool {
+	if strings.HasSuffix(c.Repository, "/") {
+		return strings.HasPrefix(repository, c.Repository)
+	}
+
+	return repository == c.Repository
+}
+
+// Version returns the version of the given tag short name, false if the tag
+// doesn't match the TagPattern. Without TagPattern the tag name is returned.
+func (c *RepositoryConfig) Version(tag string) (string, bool) {
+	if c == nil || c.tagRegexp == nil {
+		return tag, true
+	}
+
+	m := c.tagRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return "", false
+	}
+
+	for i, name := range c.tagRegexp.SubexpNames() {
+		if name == "version" {
+			return m[i], true
+		}
+	}
+
+	if len(m) > 1 {
+		return m[1], true
+	}
+
+	return m[0], true
+}
+
+// IsCalVer returns true if the versions are calendar versions.
+func (c *RepositoryConfig) IsCalVer() bool {
+	return c != nil && c.Scheme == SchemeCalVer
+}
+
+// RepositoryConfigs is a list of RepositoryConfig.
+type RepositoryConfigs []*RepositoryConfig
+
+// Match returns the config of the given repository, the config of the exact
+// repository takes precedence over the longest matching prefix, nil if none.
+func (c RepositoryConfigs) Match(repository string) *RepositoryConfig {
+	var match *RepositoryConfig
+	for _, config := range c {
+		if !config.matches(repository) {
+			continue
+		}
+
+		if config.Repository == repository {
+			return config
+		}
+
+		if match == nil || len(config.Repository) > len(match.Repository) {
+			match = config
+		}
+	}
+
+	return match
+}
@@ -0,0 +1,118 @@
+package stable
+
+import (
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type RepositoryConfigSuite struct{}
+
+var _ = Suite(&RepositoryConfigSuite{})
+
+func (s *RepositoryConfigSuite) TestVersion(c *C) {
+	config := &RepositoryConfig{Repository: "github.com/org/", TagPattern: `^release-(?P<version>.+)$`}
+	c.Assert(config.validate(), IsNil)
+
+	version, ok := config.Version("release-1.2.3")
+	c.Assert(ok, Equals, true)
+	c.Assert(version, Equals, "1.2.3")
+
+	_, ok = config.Version("v1.2.3")
+	c.Assert(ok, Equals, false)
+
+	config = &RepositoryConfig{Repository: "github.com/org/", TagPattern: `^service/(.+)$`}
+	c.Assert(config.validate(), IsNil)
+
+	version, ok = config.Version("service/2024.05.1")
+	c.Assert(ok, Equals, true)
+	c.Assert(version, Equals, "2024.05.1")
+
+	var nilConfig *RepositoryConfig
+	version, ok = nilConfig.Version("v1.2.3")
+	c.Assert(ok, Equals, true)
+	c.Assert(version, Equals, "v1.2.3")
+}
+
+func (s *RepositoryConfigSuite) TestValidate(c *C) {
+	c.Assert((&RepositoryConfig{}).validate(), Equals, ErrInvalidRepositoryConfig)
+	c.Assert((&RepositoryConfig{Repository: "github.com/org/", TagPattern: "("}).validate(), NotNil)
+	c.Assert((&RepositoryConfig{Repository: "github.com/org/", Scheme: "foo"}).validate(), NotNil)
+	c.Assert((&RepositoryConfig{Repository: "github.com/org/", Scheme: SchemeCalVer}).validate(), IsNil)
+}
+
+func (s *RepositoryConfigSuite) TestMatch(c *C) {
+	configs := RepositoryConfigs{
+		{Repository: "github.com/"},
+		{Repository: "github.com/org/"},
+		{Repository: "github.com/org/repository"},
+	}
+
+	c.Assert(configs.Match("github.com/org/repository"), Equals, configs[2])
+	c.Assert(configs.Match("github.com/org/other"), Equals, configs[1])
+	c.Assert(configs.Match("github.com/foo/other"), Equals, configs[0])
+	c.Assert(configs.Match("gitlab.com/org/repository"), IsNil)
+}
+
+func (s *RepositoryConfigSuite) TestVersions(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/heads/master", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/release-1.2.3", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/release-1.10.0", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/release-2.0.0-rc1", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v9.0.0", plumbing.NewHash("")))
+
+	config := &RepositoryConfig{Repository: "github.com/org/repository", TagPattern: `^release-(.+)$`}
+	c.Assert(config.validate(), IsNil)
+
+	v := NewVersionsWithConfig(refs, nil, config)
+	c.Assert(v.References, HasLen, 4)
+	c.Assert(v.BestMatch("v1").Name().Short(), Equals, "release-1.10.0")
+	c.Assert(v.BestMatch("v1.2").Name().Short(), Equals, "release-1.2.3")
+	c.Assert(v.BestMatch("v9"), IsNil)
+	c.Assert(v.IsStable(v.References["release-2.0.0-rc1"]), Equals, false)
+
+	majors := v.Mayor()
+	c.Assert(majors, HasLen, 3)
+	c.Assert(majors["v0"].Name().Short(), Equals, "master")
+	c.Assert(majors["v2"].Name().Short(), Equals, "release-2.0.0-rc1")
+
+	excluded := v.Exclude(map[string]string{"release-1.10.0": "retracted: broken"})
+	c.Assert(excluded.BestMatch("v1").Name().Short(), Equals, "release-1.2.3")
+}
+
+func (s *RepositoryConfigSuite) TestVersionsGopkgIn(c *C) {
+	branch := plumbing.NewHash("1111111111111111111111111111111111111111")
+	tag := plumbing.NewHash("2222222222222222222222222222222222222222")
+
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/heads/master", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/heads/v1", branch))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1", tag))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.5.0", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.1.0", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v2.2.0-rc1", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/2.3.0", plumbing.NewHash("")))
+
+	v := NewVersions(refs, nil)
+	c.Assert(v.BestMatch("v1").Hash(), Equals, tag)
+	c.Assert(v.BestMatch("v2").Name().Short(), Equals, "2.3.0")
+
+	config := &RepositoryConfig{Repository: "gopkg.in/org/repository", Scheme: SchemeGopkgIn}
+	c.Assert(config.validate(), IsNil)
+
+	v = NewVersionsWithConfig(refs, nil, config)
+	c.Assert(v.BestMatch("v1").Hash(), Equals, branch)
+	c.Assert(v.BestMatch("v1").IsBranch(), Equals, true)
+	c.Assert(v.BestMatch("v1.5").Name().Short(), Equals, "v1.5.0")
+	c.Assert(v.BestMatch("v2").Name().Short(), Equals, "v2.1.0")
+
+	majors := v.Mayor()
+	c.Assert(majors, HasLen, 3)
+	c.Assert(majors["v1"].IsBranch(), Equals, true)
+	c.Assert(v.IsStable(majors["v1"]), Equals, false)
+	c.Assert(v.HasStable("v1"), Equals, true)
+
+	excluded := v.Exclude(map[string]string{"v1": "retracted: broken"})
+	c.Assert(excluded.BestMatch("v1").Name().Short(), Equals, "v1.5.0")
+}
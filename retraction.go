@@ -0,0 +1,146 @@
+package stable
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidRetraction = errors.New("invalid retraction, expected <repository>@<tag>")
+)
+
+// Retraction is a tag of a repository that should not be resolved, eg.: a
+// broken release.
+type Retraction struct {
+	// Repository name without scheme, eg.: github.com/org/repository
+	Repository string `json:"repository"`
+	// Tag short name, eg.: v1.2.3
+	Tag    string `json:"tag"`
+	Reason string `json:"reason"`
+}
+
+// ParseRetraction parses a retraction in the format <repository>@<tag>.
+func ParseRetraction(s, reason string) (*Retraction, error) {
+	p := strings.Split(s, "@")
+	if len(p) != 2 || p[0] == "" || p[1] == "" {
+		return nil, ErrInvalidRetraction
+	}
+
+	return &Retraction{Repository: p[0], Tag: p[1], Reason: reason}, nil
+}
+
+func (r *Retraction) String() string {
+	return r.Repository + "@" + r.Tag
+}
+
+func (r *Retraction) validate() error {
+	if r.Repository == "" || r.Tag == "" {
+		return ErrInvalidRetraction
+	}
+
+	return nil
+}
+
+// Retractions is a store of retractions, safe for concurrent use.
+type Retractions struct {
+	mu sync.RWMutex
+	m  map[string]map[string]*Retraction
+}
+
+func NewRetractions() *Retractions {
+	return &Retractions{m: make(map[string]map[string]*Retraction, 0)}
+}
+
+// Add adds or replaces a retraction.
+func (r *Retractions) Add(retraction *Retraction) error {
+	if err := retraction.validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.m[retraction.Repository]; !ok {
+		r.m[retraction.Repository] = make(map[string]*Retraction, 0)
+	}
+
+	r.m[retraction.Repository][retraction.Tag] = retraction
+	return nil
+}
+
+// Remove removes a retraction, returns false if the retraction didn't exists.
+func (r *Retractions) Remove(repository, tag string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.m[repository][tag]; !ok {
+		return false
+	}
+
+	delete(r.m[repository], tag)
+	if len(r.m[repository]) == 0 {
+		delete(r.m, repository)
+	}
+
+	return true
+}
+
+// List returns all the retractions sorted by repository and tag.
+func (r *Retractions) List() []*Retraction {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	output := make([]*Retraction, 0)
+	for _, tags := range r.m {
+		for _, retraction := range tags {
+			output = append(output, retraction)
+		}
+	}
+
+	sort.Sort(byRetraction(output))
+	return output
+}
+
+// Repository returns the retractions of the given repository sorted by tag.
+func (r *Retractions) Repository(repository string) []*Retraction {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	output := make([]*Retraction, 0)
+	for _, retraction := range r.m[repository] {
+		output = append(output, retraction)
+	}
+
+	sort.Sort(byRetraction(output))
+	return output
+}
+
+// Exclude returns a copy of the given Versions with the retracted versions of
+// the package excluded.
+func (r *Retractions) Exclude(pkg *Package, v *Versions) *Versions {
+	retractions := r.Repository(pkg.RepositoryName())
+	if len(retractions) == 0 {
+		return v
+	}
+
+	excluded := make(map[string]string, len(retractions))
+	for _, retraction := range retractions {
+		excluded[retraction.Tag] = "retracted: " + retraction.Reason
+	}
+
+	return v.Exclude(excluded)
+}
+
+type byRetraction []*Retraction
+
+func (s byRetraction) Len() int      { return len(s) }
+func (s byRetraction) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byRetraction) Less(i, j int) bool {
+	if s[i].Repository != s[j].Repository {
+		return s[i].Repository < s[j].Repository
+	}
+
+	return s[i].Tag < s[j].Tag
+}
@@ -0,0 +1,63 @@
+package stable
+
+import (
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type RetractionsSuite struct{}
+
+var _ = Suite(&RetractionsSuite{})
+
+func (s *RetractionsSuite) TestParseRetraction(c *C) {
+	r, err := ParseRetraction("github.com/org/repository@v1.2.3", "broken")
+	c.Assert(err, IsNil)
+	c.Assert(r.Repository, Equals, "github.com/org/repository")
+	c.Assert(r.Tag, Equals, "v1.2.3")
+	c.Assert(r.Reason, Equals, "broken")
+	c.Assert(r.String(), Equals, "github.com/org/repository@v1.2.3")
+
+	_, err = ParseRetraction("github.com/org/repository", "")
+	c.Assert(err, Equals, ErrInvalidRetraction)
+}
+
+func (s *RetractionsSuite) TestAddRemove(c *C) {
+	r := NewRetractions()
+	c.Assert(r.Add(&Retraction{Repository: "github.com/org/b", Tag: "v1.0.0"}), IsNil)
+	c.Assert(r.Add(&Retraction{Repository: "github.com/org/a", Tag: "v1.0.1"}), IsNil)
+	c.Assert(r.Add(&Retraction{Repository: "github.com/org/a", Tag: "v1.0.0"}), IsNil)
+	c.Assert(r.Add(&Retraction{Repository: "github.com/org/a"}), Equals, ErrInvalidRetraction)
+
+	list := r.List()
+	c.Assert(list, HasLen, 3)
+	c.Assert(list[0].String(), Equals, "github.com/org/a@v1.0.0")
+	c.Assert(list[1].String(), Equals, "github.com/org/a@v1.0.1")
+	c.Assert(list[2].String(), Equals, "github.com/org/b@v1.0.0")
+
+	c.Assert(r.Remove("github.com/org/a", "v1.0.0"), Equals, true)
+	c.Assert(r.Remove("github.com/org/a", "v1.0.0"), Equals, false)
+	c.Assert(r.Repository("github.com/org/a"), HasLen, 1)
+}
+
+func (s *RetractionsSuite) TestExclude(c *C) {
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", plumbing.NewHash("")))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.1", plumbing.NewHash("")))
+	v := NewVersions(refs, nil)
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	r := NewRetractions()
+	r.Add(&Retraction{Repository: "github.com/org/repository", Tag: "v1.0.1", Reason: "broken"})
+
+	excluded := r.Exclude(pkg, v)
+	c.Assert(excluded.BestMatch("v1").Name().String(), Equals, "refs/tags/v1.0.0")
+	c.Assert(excluded.BestMatch("v1.0.1"), IsNil)
+	c.Assert(excluded.Match("v1"), HasLen, 1)
+	c.Assert(excluded.Excluded["v1.0.1"], Equals, "retracted: broken")
+
+	c.Assert(v.BestMatch("v1").Name().String(), Equals, "refs/tags/v1.0.1")
+}
@@ -1,17 +1,22 @@
 package stable
 
 import (
+	"net"
 	"net/http"
+	"net/url"
 	"path"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/sync/singleflight"
 )
 
 const DefaultBaseRoute = "/{org:[a-z0-9-]+}/{repository:[a-z0-9-/]+}.{version:v[0-9.]+}"
 
 type Server struct {
 	http.Server
-	r *mux.Router
+	r      *mux.Router
+	flight singleflight.Group
 
 	BaseRoute string
 	Host      string
@@ -20,6 +25,51 @@ type Server struct {
 		Organization string
 		Repository   string
 	}
+
+	// Limiter if not nil limits the requests per client and upstream.
+	Limiter *RateLimiter
+	// TrustedProxies are the proxies allowed to set the address of the client
+	// with the X-Real-IP header, see ParseTrustedProxies.
+	TrustedProxies []*net.IPNet
+	// Client is the http.Client used to call the upstream git servers, if nil
+	// http.DefaultClient is used, see NewUpstreamClient.
+	Client *http.Client
+	// MaxRequestSize is the max size of a decoded upload-pack request, if zero
+	// DefaultMaxRequestSize is used.
+	MaxRequestSize int64
+	// Cache if not nil, caches the versions advertised by the upstream.
+	Cache *VersionsCache
+	// Hooks if not nil, are the secrets of the webhooks served at /hooks/,
+	// invalidating the Cache on every push.
+	Hooks *Hooks
+	// Packs if not nil, caches the packfiles fetched from the upstream.
+	Packs *PackCache
+	// Prefetcher if not nil, tracks the requested packages to be refreshed in
+	// background.
+	Prefetcher *Prefetcher
+	// Retractions are the versions retracted by the operator.
+	Retractions *Retractions
+	// Pins are the constraints frozen to a version by the operator.
+	Pins *Pins
+	// Repositories are the settings by repository, see RepositoryConfig.
+	Repositories RepositoryConfigs
+	// Channels are the release channels by name, served at the BaseRoute with
+	// the channel name instead of the version, see Channel.
+	Channels map[string]*Channel
+	// GoModRetractions if not nil, excludes the versions retracted at go.mod.
+	GoModRetractions *GoModRetractions
+	// VerifyImports if true, the meta import is only answered if the package
+	// resolves to a version, a 404 listing the available majors otherwise.
+	VerifyImports bool
+	// Subpackages if not nil, checks that the subpackages requested at the
+	// meta import exist at the resolved version.
+	Subpackages *Subpackages
+	// Signatures if not nil, excludes every version except the signed tags.
+	Signatures *Signatures
+	// Audit if not nil, records every packfile served, see AuditLog.
+	Audit *AuditLog
+	// SumDB if not nil, is served at /sumdb/, see SumDB.
+	SumDB *SumDB
 }
 
 func NewDefaultServer(host string) *Server {
@@ -31,8 +81,11 @@ func NewDefaultServer(host string) *Server {
 
 func NewServer(base, host string) *Server {
 	s := &Server{
-		BaseRoute: base,
-		Host:      host,
+		BaseRoute:   base,
+		Host:        host,
+		Retractions: NewRetractions(),
+		Pins:        NewPins(),
+		Channels:    DefaultChannels(),
 	}
 
 	s.buildRouter()
@@ -50,11 +103,47 @@ func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
 func (s *Server) buildRouter() {
 	s.r = mux.NewRouter()
 	s.r.HandleFunc("/", s.doRootRedirect).Methods("GET").Name("base")
-	s.r.HandleFunc(path.Join(s.BaseRoute, "/info/refs"), s.doUploadPackInfoResponse).Methods("GET")
-	s.r.HandleFunc(path.Join(s.BaseRoute, "/git-upload-pack"), s.doUploadPackResponse).Methods("POST")
-	s.r.HandleFunc(path.Join(s.BaseRoute, "/{subpkg:.+}"), s.doMetaImportResponse).Methods("GET").Queries("go-get", "1")
-	s.r.HandleFunc(path.Join(s.BaseRoute, "/{subpkg:.+}"), s.doPackageRedirect).Methods("GET")
-	s.r.HandleFunc(s.BaseRoute, s.doMetaImportResponse).Methods("GET").Queries("go-get", "1")
-	s.r.HandleFunc(s.BaseRoute, s.doPackageRedirect).Methods("GET").Name("base")
+	s.r.HandleFunc("/hooks/github", s.doGitHubHookResponse).Methods("POST")
+	s.r.HandleFunc("/hooks/gitlab", s.doGitLabHookResponse).Methods("POST")
+	s.r.HandleFunc("/hooks/gitea", s.doGiteaHookResponse).Methods("POST")
+	s.r.PathPrefix("/sumdb/").Handler(http.StripPrefix("/sumdb", s.limitClient(s.doSumDBResponse)))
+	s.handleBaseRoute(s.BaseRoute, "base")
+	if route := s.channelRoute(); route != "" {
+		s.handleBaseRoute(route, "channel")
+	}
+
 	s.Handler = s.r
 }
+
+// handleBaseRoute registers the routes of the packages under the given base
+// route, the package route is registered with the given name.
+func (s *Server) handleBaseRoute(base, name string) {
+	s.r.HandleFunc(path.Join(base, "/info/refs"), s.limitClient(s.doUploadPackInfoResponse)).Methods("GET")
+	s.r.HandleFunc(path.Join(base, "/git-upload-pack"), s.limitClient(s.doUploadPackResponse)).Methods("POST")
+	s.r.HandleFunc(path.Join(base, "/@versions.json"), s.limitClient(s.doVersionsResponse)).Methods("GET")
+	s.r.HandleFunc(path.Join(base, "/{subpkg:.+}"), s.doMetaImportResponse).Methods("GET").Queries("go-get", "1")
+	s.r.HandleFunc(path.Join(base, "/{subpkg:.+}"), s.doPackageRedirect).Methods("GET")
+	s.r.HandleFunc(base, s.doMetaImportResponse).Methods("GET").Queries("go-get", "1")
+	s.r.HandleFunc(base, s.doPackageRedirect).Methods("GET").Name(name)
+}
+
+// Package returns the Package matching the given go-stable URL, the scheme is
+// optional, eg.: example.com/org/repository.v1
+func (s *Server) Package(rawurl string) (*Package, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "https://" + rawurl
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	var m mux.RouteMatch
+	r := &http.Request{Method: "GET", URL: u, Host: u.Host}
+	if !s.r.Match(r, &m) || m.Vars[ConstraintKey] == "" {
+		return nil, ErrPackageNotFound
+	}
+
+	return s.buildPackageFromVars(m.Vars), nil
+}
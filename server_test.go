@@ -0,0 +1,27 @@
+package stable
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type ServerSuite struct{}
+
+var _ = Suite(&ServerSuite{})
+
+func (s *ServerSuite) TestPackage(c *C) {
+	server := NewDefaultServer("foo.bar")
+
+	pkg, err := server.Package("foo.bar/org/repository.v1/subpackage")
+	c.Assert(err, IsNil)
+	c.Assert(pkg.Name, Equals, "foo.bar/org/repository.v1")
+	c.Assert(pkg.Repository.String(), Equals, "https://github.com/org/repository")
+	c.Assert(pkg.Constrain, Equals, "v1")
+}
+
+func (s *ServerSuite) TestPackageNotFound(c *C) {
+	server := NewDefaultServer("foo.bar")
+
+	pkg, err := server.Package("https://foo.bar/")
+	c.Assert(err, Equals, ErrPackageNotFound)
+	c.Assert(pkg, IsNil)
+}
@@ -0,0 +1,597 @@
+package stable
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+var (
+	ErrUnsignedTag      = errors.New("unsigned tag")
+	ErrUnknownSigner    = errors.New("signed by an unknown key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrTagNameMismatch  = errors.New("tag object name doesn't match the reference")
+)
+
+const (
+	pgpSignatureHeader = "-----BEGIN PGP SIGNATURE-----"
+	sshSignatureHeader = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureFooter = "-----END SSH SIGNATURE-----"
+	sshSignatureMagic  = "SSHSIG"
+	// sshSignatureNamespace is the namespace used by git signing with ssh keys.
+	sshSignatureNamespace = "git"
+)
+
+// Signatures excludes the versions that are not annotated tags signed by any of
+// the trusted keys, OpenPGP keys or SSH allowed signers. The tag objects are
+// fetched from the upstream by Prepare, and the result of the verification is
+// cached by tag name and object hash, since the tag objects are immutable.
+type Signatures struct {
+	PGP openpgp.EntityList
+	SSH []*AllowedSigner
+
+	mu    sync.Mutex
+	cache map[signedTag]string
+}
+
+// signedTag is the key of the verifications, the same tag object can be
+// referenced by any tag, but is only valid for the tag it names.
+type signedTag struct {
+	Name string
+	Hash plumbing.Hash
+}
+
+func newSignedTag(ref *plumbing.Reference) signedTag {
+	return signedTag{Name: ref.Name().Short(), Hash: ref.Hash()}
+}
+
+// AllowedSigner is an entry of a SSH allowed signers file, see the ALLOWED
+// SIGNERS section of ssh-keygen(1).
+type AllowedSigner struct {
+	// Principals are the patterns matching the email of the tagger, eg.:
+	// *@example.com
+	Principals []string
+	// Namespaces are the namespaces the key is allowed to sign, any if empty.
+	Namespaces []string
+	// ValidAfter and ValidBefore limit the time of the tag, if not zero.
+	ValidAfter  time.Time
+	ValidBefore time.Time
+	Key         ssh.PublicKey
+}
+
+func NewSignatures() *Signatures {
+	return &Signatures{cache: make(map[signedTag]string, 0)}
+}
+
+// LoadPGPKeyring adds the public keys from the given armored OpenPGP keyring,
+// as is exported by `gpg --export --armor`.
+func (s *Signatures) LoadPGPKeyring(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return fmt.Errorf("invalid keyring %s: %s", filename, err)
+	}
+
+	s.PGP = append(s.PGP, keyring...)
+	return nil
+}
+
+// LoadSSHAllowedSigners adds the signers from the given allowed signers file,
+// as used by gpg.ssh.allowedSignersFile. The options supported are namespaces,
+// valid-after and valid-before, the entries with other options are rejected.
+func (s *Signatures) LoadSSHAllowedSigners(filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	signers, err := parseAllowedSigners(content)
+	if err != nil {
+		return fmt.Errorf("invalid allowed signers %s: %s", filename, err)
+	}
+
+	s.SSH = append(s.SSH, signers...)
+	return nil
+}
+
+func parseAllowedSigners(content []byte) ([]*AllowedSigner, error) {
+	var signers []*AllowedSigner
+	for n, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: missing key", n+1)
+		}
+
+		// the first field are the principals, the rest is in the same format as
+		// authorized_keys, with optional options
+		rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+
+		signer := &AllowedSigner{Principals: strings.Split(fields[0], ","), Key: key}
+		for _, option := range options {
+			if err := signer.setOption(option); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n+1, err)
+			}
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+func (a *AllowedSigner) setOption(option string) error {
+	name, value := option, ""
+	if i := strings.Index(option, "="); i >= 0 {
+		name, value = option[:i], strings.Trim(option[i+1:], `"`)
+	}
+
+	var err error
+	switch strings.ToLower(name) {
+	case "namespaces":
+		a.Namespaces = strings.Split(value, ",")
+	case "valid-after":
+		a.ValidAfter, err = parseAllowedSignerTime(value)
+	case "valid-before":
+		a.ValidBefore, err = parseAllowedSignerTime(value)
+	default:
+		return fmt.Errorf("unsupported option %q", name)
+	}
+
+	return err
+}
+
+// parseAllowedSignerTime parses a time as YYYYMMDD[HHMM[SS]], in the local
+// time zone unless is suffixed by Z.
+func parseAllowedSignerTime(value string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") {
+		value, loc = strings.TrimSuffix(value, "Z"), time.UTC
+	}
+
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(value) == len(layout) {
+			return time.ParseInLocation(layout, value, loc)
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// allows returns nil if the signer allows the signature of the tag of the
+// given tagger email and time, with the given namespace.
+func (a *AllowedSigner) allows(email string, when time.Time, namespace string) error {
+	if !a.matchesPrincipal(email) {
+		return fmt.Errorf("principal %q not allowed", email)
+	}
+
+	if len(a.Namespaces) != 0 && !a.matchesNamespace(namespace) {
+		return fmt.Errorf("namespace %q not allowed", namespace)
+	}
+
+	if !a.ValidAfter.IsZero() && when.Before(a.ValidAfter) {
+		return fmt.Errorf("key not valid before %s", a.ValidAfter.Format(time.RFC3339))
+	}
+
+	if !a.ValidBefore.IsZero() && !when.Before(a.ValidBefore) {
+		return fmt.Errorf("key not valid after %s", a.ValidBefore.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func (a *AllowedSigner) matchesPrincipal(email string) bool {
+	for _, pattern := range a.Principals {
+		if ok, _ := path.Match(pattern, email); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *AllowedSigner) matchesNamespace(namespace string) bool {
+	for _, pattern := range a.Namespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Prepare fetches and verifies the annotated tags not verified yet, the tags
+// excluded are reported to the stderr once, when are verified. An upstream
+// call is made only if the limiter allows it.
+func (s *Signatures) Prepare(ctx context.Context, f *Fetcher, l *RateLimiter, pkg *Package, v *Versions) error {
+	if s == nil {
+		return nil
+	}
+
+	results, err := s.fetch(ctx, f, l, pkg, v)
+	for _, ref := range v.References {
+		if !ref.IsTag() {
+			continue
+		}
+
+		if _, ok := v.Peeled[ref.Name()]; !ok {
+			results[newSignedTag(ref)] = ErrUnsignedTag.Error()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, ref := range v.References {
+		reason, ok := results[newSignedTag(ref)]
+		if _, cached := s.cache[newSignedTag(ref)]; !ok || cached {
+			continue
+		}
+
+		if reason != "" {
+			fmt.Fprintf(os.Stderr, "tag %s of %s excluded: %s\n", name, pkg.RepositoryName(), reason)
+		}
+	}
+
+	for tag, reason := range results {
+		s.cache[tag] = reason
+	}
+
+	return err
+}
+
+// Exclude returns a copy of the given Versions where every version, except the
+// tags with a valid signature, is excluded. Only the results of Prepare are
+// used, the tags not verified yet are excluded.
+func (s *Signatures) Exclude(v *Versions) *Versions {
+	if s == nil {
+		return v
+	}
+
+	excluded := make(map[string]string, 0)
+	for name, ref := range v.References {
+		if v.IsExcluded(name) {
+			continue
+		}
+
+		if reason := s.verify(v, ref); reason != "" {
+			excluded[name] = reason
+		}
+	}
+
+	return v.Exclude(excluded)
+}
+
+// verify returns the reason of the exclusion of the given reference, or an
+// empty string when the signature is valid.
+func (s *Signatures) verify(v *Versions, ref *plumbing.Reference) string {
+	if !ref.IsTag() {
+		return "not a signed tag"
+	}
+
+	if _, ok := v.Peeled[ref.Name()]; !ok {
+		return ErrUnsignedTag.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reason, ok := s.cache[newSignedTag(ref)]
+	if !ok {
+		return "signature not verified: tag object not available"
+	}
+
+	return reason
+}
+
+// fetch fetches and verifies the annotated tags not present in the cache, just
+// the tag objects are fetched, announcing the tagged commits as haves. The
+// results are returned by tag name and object hash.
+func (s *Signatures) fetch(ctx context.Context, f *Fetcher, l *RateLimiter, pkg *Package, v *Versions) (map[signedTag]string, error) {
+	results := make(map[signedTag]string, 0)
+
+	var tags []signedTag
+	var wants, haves []plumbing.Hash
+	s.mu.Lock()
+	for name, commit := range v.Peeled {
+		ref, ok := v.References[name.Short()]
+		if !ok || !ref.IsTag() || ref.Name() != name {
+			continue
+		}
+
+		if _, ok := s.cache[newSignedTag(ref)]; !ok {
+			tags = append(tags, newSignedTag(ref))
+			wants = append(wants, ref.Hash())
+			haves = append(haves, commit)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(wants) == 0 {
+		return results, nil
+	}
+
+	if !l.AllowUpstream(pkg.Repository.Host) {
+		return results, ErrRateLimited
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := f.FetchObjects(ctx, buf, wants, haves); err != nil {
+		return results, err
+	}
+
+	storage, err := decodePackfile(buf)
+	if err != nil {
+		return results, err
+	}
+
+	for _, tag := range tags {
+		obj, err := storage.EncodedObject(plumbing.TagObject, tag.Hash)
+		if err != nil {
+			continue
+		}
+
+		r, err := obj.Reader()
+		if err != nil {
+			return results, err
+		}
+
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return results, err
+		}
+
+		results[tag] = ""
+		if err := s.verifyTag(tag.Name, content); err != nil {
+			results[tag] = err.Error()
+		}
+	}
+
+	return results, nil
+}
+
+// verifyTag verifies the signature of the given raw tag object of the tag with
+// the given name, the signature is at the end of the message. As git does, the
+// last signature is the one verified, the previous ones are part of the
+// message.
+func (s *Signatures) verifyTag(name string, content []byte) error {
+	if tagged := parseTagName(content); tagged != name {
+		return fmt.Errorf("%s: named %q", ErrTagNameMismatch, tagged)
+	}
+
+	i, header := -1, ""
+	for _, h := range []string{pgpSignatureHeader, sshSignatureHeader} {
+		if j := bytes.LastIndex(content, []byte("\n"+h)); j > i {
+			i, header = j, h
+		}
+	}
+
+	if i < 0 {
+		return ErrUnsignedTag
+	}
+
+	payload, signature := content[:i+1], content[i+1:]
+	if header == pgpSignatureHeader {
+		return s.verifyPGP(payload, signature)
+	}
+
+	return s.verifySSH(payload, signature)
+}
+
+func (s *Signatures) verifyPGP(payload, signature []byte) error {
+	if len(s.PGP) == 0 {
+		return ErrUnknownSigner
+	}
+
+	_, err := openpgp.CheckArmoredDetachedSignature(
+		s.PGP, bytes.NewReader(payload), bytes.NewReader(signature),
+	)
+
+	switch err {
+	case nil:
+		return nil
+	case openpgp.ErrUnknownIssuer:
+		return ErrUnknownSigner
+	default:
+		return fmt.Errorf("%s: %s", ErrInvalidSignature, err)
+	}
+}
+
+// sshSignature is the content of a SSH signature, see the PROTOCOL.sshsig file
+// of OpenSSH.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func (s *Signatures) verifySSH(payload, signature []byte) error {
+	sig, err := parseSSHSignature(signature)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidSignature, err)
+	}
+
+	key, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidSignature, err)
+	}
+
+	if err := s.allowedSigner(key, payload, sig.Namespace); err != nil {
+		return err
+	}
+
+	if sig.Namespace != sshSignatureNamespace {
+		return fmt.Errorf("%s: unexpected namespace %q", ErrInvalidSignature, sig.Namespace)
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("%s: unsupported hash %q", ErrInvalidSignature, sig.HashAlgorithm)
+	}
+
+	h.Write(payload)
+	data := append([]byte(sshSignatureMagic), ssh.Marshal(&sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+
+	blob := &ssh.Signature{}
+	if err := ssh.Unmarshal(sig.Signature, blob); err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidSignature, err)
+	}
+
+	if err := key.Verify(data, blob); err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
+// allowedSigner returns nil if any of the allowed signers with the given key
+// allows the signature of the given tag payload, ErrUnknownSigner otherwise.
+func (s *Signatures) allowedSigner(key ssh.PublicKey, payload []byte, namespace string) error {
+	email, when := parseTagger(payload)
+
+	var reasons []string
+	marshaled := key.Marshal()
+	for _, signer := range s.SSH {
+		if !bytes.Equal(signer.Key.Marshal(), marshaled) {
+			continue
+		}
+
+		err := signer.allows(email, when, namespace)
+		if err == nil {
+			return nil
+		}
+
+		reasons = append(reasons, err.Error())
+	}
+
+	if len(reasons) == 0 {
+		return ErrUnknownSigner
+	}
+
+	return fmt.Errorf("%s: %s", ErrUnknownSigner, strings.Join(reasons, ", "))
+}
+
+// parseTagName returns the name of the tag header of the given raw tag object.
+func parseTagName(payload []byte) string {
+	for _, line := range strings.Split(string(payload), "\n") {
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, "tag ") {
+			return line[len("tag "):]
+		}
+	}
+
+	return ""
+}
+
+// parseTagger returns the email and the time of the tagger header of the given
+// raw tag object.
+func parseTagger(payload []byte) (email string, when time.Time) {
+	for _, line := range strings.Split(string(payload), "\n") {
+		if line == "" {
+			break
+		}
+
+		if !strings.HasPrefix(line, "tagger ") {
+			continue
+		}
+
+		start, end := strings.LastIndex(line, "<"), strings.LastIndex(line, ">")
+		if start < 0 || end < start {
+			return "", time.Time{}
+		}
+
+		email = line[start+1 : end]
+		fields := strings.Fields(line[end+1:])
+		if len(fields) > 0 {
+			if sec, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				when = time.Unix(sec, 0)
+			}
+		}
+
+		return email, when
+	}
+
+	return "", time.Time{}
+}
+
+// parseSSHSignature parses an armored SSH signature.
+func parseSSHSignature(armored []byte) (*sshSignature, error) {
+	text := strings.TrimSpace(string(armored))
+	if !strings.HasPrefix(text, sshSignatureHeader) || !strings.HasSuffix(text, sshSignatureFooter) {
+		return nil, errors.New("malformed armor")
+	}
+
+	text = strings.TrimSuffix(strings.TrimPrefix(text, sshSignatureHeader), sshSignatureFooter)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshSignatureMagic)) {
+		return nil, errors.New("missing magic preamble")
+	}
+
+	sig := &sshSignature{}
+	if err := ssh.Unmarshal(blob[len(sshSignatureMagic):], sig); err != nil {
+		return nil, err
+	}
+
+	if sig.Version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", sig.Version)
+	}
+
+	return sig, nil
+}
@@ -0,0 +1,251 @@
+package stable
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+type SignaturesSuite struct{}
+
+var _ = Suite(&SignaturesSuite{})
+
+const fixtureSSHTag = `object f07474b57ed4c07fd2d8b474a08804fffbe1731c
+type commit
+tag v1.0.0
+tagger Release <release@example.com> 1792422484 +0000
+
+v1.0.0
+-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgq9y5d7qUtVDmcyED7+EO1twOJR
+Uu5Q2D0Q94RL+RhDUAAAADZ2l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5
+AAAAQBT4f6wKjmGX8qtihrWaEkAzmZpqTvCJztqgSwx/claiVtoPMvD9cYAiRV0PULGpnv
+fUiqe3C8nNcaYxGEywgwg=
+-----END SSH SIGNATURE-----
+`
+
+const fixturePGPTag = `object f07474b57ed4c07fd2d8b474a08804fffbe1731c
+type commit
+tag v1.1.0
+tagger Release <release@example.com> 1792422489 +0000
+
+v1.1.0
+-----BEGIN PGP SIGNATURE-----
+
+iIoEABMIADIWIQSc5HcbY0ztJ9oWC13b2n0aQh5XvAUCatYyWRQccmVsZWFzZUBl
+eGFtcGxlLmNvbQAKCRDb2n0aQh5XvGDeAP9E57oOBsuXc34RNFKSwB/uExcN6FXD
+zNcQBsT5wHKvkgD+IldQLmmPxkkMDivkDFWGmQ9iDSBPTFOk1u02A8Opqds=
+=SRvK
+-----END PGP SIGNATURE-----
+`
+
+const fixtureUnsignedTag = `object f07474b57ed4c07fd2d8b474a08804fffbe1731c
+type commit
+tag v1.2.0
+tagger Release <release@example.com> 1792422484 +0000
+
+v1.2.0
+`
+
+const fixturePGPKeyring = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mFIEatYyWRMIKoZIzj0DAQcCAwSTfixCtcr2qLOoUgJv9GFU7zzQGoz4ncCHbCPb
+m+2PFhoNj1IYkifreygsWobXDB4hV5wvwC+OXcpSCKlDfS2jtB1SZWxlYXNlIDxy
+ZWxlYXNlQGV4YW1wbGUuY29tPoiQBBMTCAA4FiEEnOR3G2NM7SfaFgtd29p9GkIe
+V7wFAmrWMlkCGwMFCwkIBwIGFQoJCAsCBBYCAwECHgECF4AACgkQ29p9GkIeV7yC
+BwD9GOTF1CnfC46SlYeI2AL4nBrGt2QYfN35JoYEuLjjvpYBAJj4mJ9snDa80ZJI
+C8Pe8l74mJgz3HgDnMz2nMC9xALV
+=IfLd
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+const fixtureAllowedSigners = `# release keys
+release@example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKvcuXe6lLVQ5nMhA+/hDtbcDiUVLuUNg9EPeES/kYQ1 release
+other@example.com namespaces="git" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIF2rEdVKnFaDqiyiPI+Aj4IdjPrSpedeftnQBoJdIrp+ other
+`
+
+func (s *SignaturesSuite) newSignatures(c *C) *Signatures {
+	sigs := NewSignatures()
+
+	var err error
+	sigs.PGP, err = openpgp.ReadArmoredKeyRing(strings.NewReader(fixturePGPKeyring))
+	c.Assert(err, IsNil)
+
+	sigs.SSH, err = parseAllowedSigners([]byte(fixtureAllowedSigners))
+	c.Assert(err, IsNil)
+
+	return sigs
+}
+
+func (s *SignaturesSuite) TestParseAllowedSigners(c *C) {
+	signers, err := parseAllowedSigners([]byte(fixtureAllowedSigners))
+	c.Assert(err, IsNil)
+	c.Assert(signers, HasLen, 2)
+	c.Assert(signers[0].Key.Type(), Equals, "ssh-ed25519")
+	c.Assert(signers[0].Principals, DeepEquals, []string{"release@example.com"})
+	c.Assert(signers[1].Namespaces, DeepEquals, []string{"git"})
+
+	signers, err = parseAllowedSigners([]byte(
+		`*@example.com,foo@bar.com valid-after="20240101",valid-before="202601021504Z" ` +
+			`ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKvcuXe6lLVQ5nMhA+/hDtbcDiUVLuUNg9EPeES/kYQ1`,
+	))
+	c.Assert(err, IsNil)
+	c.Assert(signers[0].Principals, DeepEquals, []string{"*@example.com", "foo@bar.com"})
+	c.Assert(signers[0].ValidAfter.Year(), Equals, 2024)
+	c.Assert(signers[0].ValidBefore, Equals, time.Date(2026, 1, 2, 15, 4, 0, 0, time.UTC))
+
+	_, err = parseAllowedSigners([]byte("release@example.com ssh-ed25519"))
+	c.Assert(err, NotNil)
+
+	_, err = parseAllowedSigners([]byte(
+		"release@example.com cert-authority ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKvcuXe6lLVQ5nMhA+/hDtbcDiUVLuUNg9EPeES/kYQ1",
+	))
+	c.Assert(err, ErrorMatches, `line 1: unsupported option "cert-authority"`)
+
+	_, err = parseAllowedSigners([]byte(
+		`release@example.com valid-after="2024" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKvcuXe6lLVQ5nMhA+/hDtbcDiUVLuUNg9EPeES/kYQ1`,
+	))
+	c.Assert(err, NotNil)
+}
+
+func (s *SignaturesSuite) TestVerifyTagSSHAllowedSigner(c *C) {
+	sigs := s.newSignatures(c)
+	signer := sigs.SSH[0]
+
+	signer.Principals = []string{"*@example.com"}
+	c.Assert(sigs.verifyTag("v1.0.0", []byte(fixtureSSHTag)), IsNil)
+
+	signer.Principals = []string{"other@example.com"}
+	c.Assert(sigs.verifyTag("v1.0.0", []byte(fixtureSSHTag)), ErrorMatches, `signed by an unknown key: principal "release@example.com" not allowed`)
+
+	signer.Principals = []string{"release@example.com"}
+	signer.Namespaces = []string{"file"}
+	c.Assert(sigs.verifyTag("v1.0.0", []byte(fixtureSSHTag)), ErrorMatches, `signed by an unknown key: namespace "git" not allowed`)
+
+	signer.Namespaces = nil
+	signer.ValidBefore = time.Unix(1792422484, 0)
+	c.Assert(sigs.verifyTag("v1.0.0", []byte(fixtureSSHTag)), ErrorMatches, `signed by an unknown key: key not valid after .*`)
+
+	signer.ValidBefore = time.Time{}
+	signer.ValidAfter = time.Unix(1792422485, 0)
+	c.Assert(sigs.verifyTag("v1.0.0", []byte(fixtureSSHTag)), ErrorMatches, `signed by an unknown key: key not valid before .*`)
+}
+
+func (s *SignaturesSuite) TestParseTagger(c *C) {
+	email, when := parseTagger([]byte(fixtureSSHTag))
+	c.Assert(email, Equals, "release@example.com")
+	c.Assert(when.Unix(), Equals, int64(1792422484))
+}
+
+func (s *SignaturesSuite) TestVerifyTagSSH(c *C) {
+	sigs := s.newSignatures(c)
+	c.Assert(sigs.verifyTag("v1.0.0", []byte(fixtureSSHTag)), IsNil)
+
+	tampered := strings.Replace(fixtureSSHTag, "\nv1.0.0\n", "\nv1.0.1\n", 1)
+	err := sigs.verifyTag("v1.0.0", []byte(tampered))
+	c.Assert(err, NotNil)
+	c.Assert(strings.HasPrefix(err.Error(), ErrInvalidSignature.Error()), Equals, true)
+
+	sigs.SSH = sigs.SSH[1:]
+	c.Assert(sigs.verifyTag("v1.0.0", []byte(fixtureSSHTag)), Equals, ErrUnknownSigner)
+}
+
+func (s *SignaturesSuite) TestVerifyTagPGP(c *C) {
+	sigs := s.newSignatures(c)
+	c.Assert(sigs.verifyTag("v1.1.0", []byte(fixturePGPTag)), IsNil)
+
+	tampered := strings.Replace(fixturePGPTag, "\nv1.1.0\n", "\nv1.1.1\n", 1)
+	c.Assert(sigs.verifyTag("v1.1.0", []byte(tampered)), NotNil)
+
+	sigs.PGP = nil
+	c.Assert(sigs.verifyTag("v1.1.0", []byte(fixturePGPTag)), Equals, ErrUnknownSigner)
+}
+
+func (s *SignaturesSuite) TestVerifyTagNameMismatch(c *C) {
+	sigs := s.newSignatures(c)
+	err := sigs.verifyTag("v9.0.0", []byte(fixtureSSHTag))
+	c.Assert(err, ErrorMatches, `tag object name doesn't match the reference: named "v1.0.0"`)
+}
+
+func (s *SignaturesSuite) TestVerifyTagLastSignature(c *C) {
+	sigs := s.newSignatures(c)
+
+	// the valid signature is part of the message, the last one is verified
+	appended := fixtureSSHTag + sshSignatureHeader + "\nU1NIU0lH\n" + sshSignatureFooter + "\n"
+	err := sigs.verifyTag("v1.0.0", []byte(appended))
+	c.Assert(err, NotNil)
+	c.Assert(strings.HasPrefix(err.Error(), ErrInvalidSignature.Error()), Equals, true)
+}
+
+func (s *SignaturesSuite) TestVerifyTagUnsigned(c *C) {
+	sigs := s.newSignatures(c)
+	c.Assert(sigs.verifyTag("v1.2.0", []byte(fixtureUnsignedTag)), Equals, ErrUnsignedTag)
+}
+
+func (s *SignaturesSuite) TestExcludeCached(c *C) {
+	commit := plumbing.NewHash("f07474b57ed4c07fd2d8b474a08804fffbe1731c")
+	signed := plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")
+	unsigned := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", signed))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.1.0", unsigned))
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.2.0", commit))
+	refs.SetReference(plumbing.NewHashReference("refs/heads/master", commit))
+	v := NewVersions(refs, map[string]plumbing.Hash{
+		"refs/tags/v1.0.0": commit,
+		"refs/tags/v1.1.0": commit,
+	})
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	sigs := s.newSignatures(c)
+	sigs.cache[signedTag{"v1.0.0", signed}] = ""
+	sigs.cache[signedTag{"v1.1.0", unsigned}] = ErrUnsignedTag.Error()
+
+	// every annotated tag is cached, so the upstream is never called
+	c.Assert(sigs.Prepare(context.Background(), nil, nil, pkg, v), IsNil)
+	c.Assert(sigs.cache[signedTag{"v1.2.0", commit}], Equals, ErrUnsignedTag.Error())
+
+	excluded := sigs.Exclude(v)
+	c.Assert(excluded.BestMatch("v1").Name().String(), Equals, "refs/tags/v1.0.0")
+	c.Assert(excluded.BestMatch("master"), IsNil)
+	c.Assert(excluded.Excluded["v1.1.0"], Equals, "unsigned tag")
+	c.Assert(excluded.Excluded["v1.2.0"], Equals, "unsigned tag")
+	c.Assert(excluded.Excluded["master"], Equals, "not a signed tag")
+}
+
+func (s *SignaturesSuite) TestPrepareRateLimited(c *C) {
+	commit := plumbing.NewHash("f07474b57ed4c07fd2d8b474a08804fffbe1731c")
+	signed := plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea")
+
+	refs := make(memory.ReferenceStorage, 0)
+	refs.SetReference(plumbing.NewHashReference("refs/tags/v1.0.0", signed))
+	v := NewVersions(refs, map[string]plumbing.Hash{"refs/tags/v1.0.0": commit})
+
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/org/repository")
+
+	l := NewRateLimiter(0, 0, 0.001, 1)
+	l.AllowUpstream("github.com")
+
+	sigs := s.newSignatures(c)
+	c.Assert(sigs.Prepare(context.Background(), nil, l, pkg, v), Equals, ErrRateLimited)
+	c.Assert(sigs.cache, HasLen, 0)
+	c.Assert(sigs.Exclude(v).Excluded["v1.0.0"], Equals, "signature not verified: tag object not available")
+}
+
+func (s *SignaturesSuite) TestExcludeNil(c *C) {
+	v := NewVersions(make(memory.ReferenceStorage, 0), nil)
+
+	var sigs *Signatures
+	c.Assert(sigs.Prepare(context.Background(), nil, nil, nil, v), IsNil)
+	c.Assert(sigs.Exclude(v), Equals, v)
+}
@@ -0,0 +1,115 @@
+package stable
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+var (
+	ErrFileNotFound = errors.New("file not found")
+)
+
+// Snapshot is the content of a commit without its history, fetched from the
+// upstream, see Fetcher.Snapshot.
+type Snapshot struct {
+	Commit *object.Commit
+	Tree   *object.Tree
+
+	storage *memory.Storage
+}
+
+// Snapshot fetches the objects of the given commits, or annotated tags, with
+// depth 1 and returns the Snapshot of the first one.
+func (f *Fetcher) Snapshot(ctx context.Context, wants ...plumbing.Hash) (*Snapshot, error) {
+	buf := bytes.NewBuffer(nil)
+	if _, err := f.FetchShallow(ctx, buf, wants...); err != nil {
+		return nil, err
+	}
+
+	storage, err := decodePackfile(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := object.GetCommit(storage, wants[0])
+	if err == plumbing.ErrObjectNotFound {
+		var tag *object.Tag
+		if tag, err = object.GetTag(storage, wants[0]); err == nil {
+			commit, err = tag.Commit()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{Commit: commit, Tree: tree, storage: storage}, nil
+}
+
+// decodePackfile decodes the packfile read from r into a new memory storage.
+func decodePackfile(r io.Reader) (*memory.Storage, error) {
+	storage := memory.NewStorage()
+	d, err := packfile.NewDecoder(packfile.NewScanner(r), storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.Decode(); err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// File returns the content of the file at the given path.
+func (s *Snapshot) File(path string) ([]byte, error) {
+	f, err := s.Tree.File(path)
+	if err == object.ErrFileNotFound {
+		return nil, ErrFileNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(content), nil
+}
+
+// Files returns the content of all the regular files by path, the symbolic
+// links and submodules are ignored.
+func (s *Snapshot) Files() (map[string][]byte, error) {
+	files := make(map[string][]byte, 0)
+	err := s.Tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Symlink {
+			return nil
+		}
+
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		files[f.Name] = []byte(content)
+		return nil
+	})
+
+	return files, err
+}
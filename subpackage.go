@@ -0,0 +1,129 @@
+package stable
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// subpackagesCacheSize is the max number of commits cached by Subpackages,
+// the cache is emptied when is reached.
+const subpackagesCacheSize = 1024
+
+// Subpackages checks that the subpackages requested at the meta import exist
+// at the resolved version, the directories containing go files are cached by
+// commit.
+type Subpackages struct {
+	mu     sync.Mutex
+	cache  map[plumbing.Hash]map[string]bool
+	flight singleflight.Group
+}
+
+func NewSubpackages() *Subpackages {
+	return &Subpackages{cache: make(map[plumbing.Hash]map[string]bool, 0)}
+}
+
+// Exists returns true if the given directory contains go files at the given
+// commit. If the commit isn't cached, it's fetched using f, once for the
+// concurrent calls, and only if the rate limit of the upstream allows it.
+func (s *Subpackages) Exists(ctx context.Context, f *Fetcher, l *RateLimiter, commit plumbing.Hash, dir string) (bool, error) {
+	packages, err := s.packages(ctx, f, l, commit)
+	if err != nil {
+		return false, err
+	}
+
+	return packages[path.Clean(dir)], nil
+}
+
+func (s *Subpackages) packages(ctx context.Context, f *Fetcher, l *RateLimiter, commit plumbing.Hash) (map[string]bool, error) {
+	s.mu.Lock()
+	packages, ok := s.cache[commit]
+	s.mu.Unlock()
+
+	if ok {
+		return packages, nil
+	}
+
+	// the callers already resolved the commit with their own credentials, so
+	// the snapshot is shared by repository
+	key := f.pkg.RepositoryName() + "@" + commit.String()
+	for {
+		v, err, shared := s.flight.Do(key, func() (interface{}, error) {
+			if !l.AllowUpstream(f.pkg.Repository.Host) {
+				return nil, ErrRateLimited
+			}
+
+			snapshot, err := f.Snapshot(ctx, commit)
+			if err != nil {
+				return nil, err
+			}
+
+			packages, err := snapshot.Packages()
+			if err != nil {
+				return nil, err
+			}
+
+			s.mu.Lock()
+			if len(s.cache) >= subpackagesCacheSize {
+				s.cache = make(map[plumbing.Hash]map[string]bool, 0)
+			}
+
+			s.cache[commit] = packages
+			s.mu.Unlock()
+			return packages, nil
+		})
+
+		// the call that started the shared fetch was canceled, not this one
+		if shared && isCanceled(err) && ctx.Err() == nil {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return v.(map[string]bool), nil
+	}
+}
+
+// Packages returns the directories containing go files, the root is ".".
+func (s *Snapshot) Packages() (map[string]bool, error) {
+	packages := make(map[string]bool, 0)
+	err := s.Tree.Files().ForEach(func(f *object.File) error {
+		if strings.HasSuffix(f.Name, ".go") {
+			packages[path.Dir(f.Name)] = true
+		}
+
+		return nil
+	})
+
+	return packages, err
+}
+
+// checkSubpackage writes a 404 response and returns false if the subpackage
+// of the given package doesn't exist at the resolved version.
+func (s *Server) checkSubpackage(w http.ResponseWriter, r *http.Request, f *Fetcher, pkg *Package, ref, peeled *plumbing.Reference) bool {
+	exists, err := s.Subpackages.Exists(r.Context(), f, s.Limiter, peeled.Hash(), pkg.Subpackage)
+	if err != nil {
+		s.handleError(w, r, err)
+		return false
+	}
+
+	if !exists {
+		http.Error(w, fmt.Sprintf(
+			"package %s not found: %s doesn't contain go files at %s",
+			path.Join(pkg.Name, pkg.Subpackage), pkg.Subpackage, ref.Name().Short(),
+		), http.StatusNotFound)
+
+		return false
+	}
+
+	return true
+}
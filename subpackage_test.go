@@ -0,0 +1,81 @@
+package stable
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+type SubpackagesSuite struct{}
+
+var _ = Suite(&SubpackagesSuite{})
+
+func (s *SubpackagesSuite) TestExists(c *C) {
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/git-fixtures/basic")
+
+	f := NewFetcher(pkg, nil)
+	commit := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	// a single call to the upstream is allowed, the next ones hit the cache
+	l := NewRateLimiter(0, 0, 0.001, 1)
+
+	subpackages := NewSubpackages()
+	exists, err := subpackages.Exists(context.Background(), f, l, commit, "go")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	c.Assert(subpackages.cache, HasLen, 1)
+
+	exists, err = subpackages.Exists(context.Background(), f, l, commit, "vendor/")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	exists, err = subpackages.Exists(context.Background(), f, l, commit, "php")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	_, err = subpackages.Exists(context.Background(), f, l, plumbing.NewHash("1669dce138d9b841a518c64b10914d88f5e488ea"), "go")
+	c.Assert(err, Equals, ErrRateLimited)
+}
+
+func (s *SubpackagesSuite) TestExistsCached(c *C) {
+	commit := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	l := NewRateLimiter(0, 0, 0.001, 1)
+	l.AllowUpstream("github.com")
+
+	subpackages := NewSubpackages()
+	subpackages.cache[commit] = map[string]bool{".": true, "cmd/tool": true}
+
+	exists, err := subpackages.Exists(context.Background(), nil, l, commit, "cmd/tool/")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+}
+
+func (s *SubpackagesSuite) TestBuildSubpackage(c *C) {
+	c.Assert(buildSubpackage("repository", ""), Equals, "")
+	c.Assert(buildSubpackage("repository", "cmd/tool"), Equals, "cmd/tool")
+	c.Assert(buildSubpackage("repository/cmd", "tool"), Equals, "cmd/tool")
+	c.Assert(buildSubpackage("repository/cmd", ""), Equals, "cmd")
+}
+
+func (s *SubpackagesSuite) TestDoMetaImportResponse(c *C) {
+	server := NewDefaultServer("foo.bar")
+	server.Subpackages = NewSubpackages()
+	server.buildRouter()
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/basic.v0/go?go-get=1", nil)
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	r, _ = http.NewRequest("GET", "http://foo.bar/git-fixtures/basic.v0/foo/bar?go-get=1", nil)
+	w = httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(w.Body.String(), Equals, "package foo.bar/git-fixtures/basic.v0/foo/bar not found: foo/bar doesn't contain go files at master\n")
+}
@@ -0,0 +1,448 @@
+package stable
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// SumDB is a checksum database, compatible with sum.golang.org, for the
+// packages served by a Server. The go.sum lines of every version are computed
+// on demand and appended to a transparency log, persisted at a file, so the
+// log is never forked between restarts. Every record is synced to disk before
+// being published, and the number of published records is persisted next to
+// the log, at filename plus ".size", to detect a log truncated on disk.
+type SumDB struct {
+	Server *Server
+	// Auth is used to fetch the private repositories from the upstream, the
+	// requests to the checksum database don't carry the client credentials.
+	Auth transport.AuthMethod
+
+	signer  note.Signer
+	handler http.Handler
+	file    *os.File
+	size    *os.File
+	flight  singleflight.Group
+
+	mu      sync.RWMutex
+	records [][]byte
+	hashes  []tlog.Hash
+	index   map[module.Version]int64
+}
+
+// NewSumDB returns a SumDB for the given Server, the log is persisted at
+// filename and the tree heads are signed with the given note signer key, as is
+// generated by note.GenerateKey.
+func NewSumDB(s *Server, key, filename string) (*SumDB, error) {
+	signer, err := note.NewSigner(strings.TrimSpace(key))
+	if err != nil {
+		return nil, err
+	}
+
+	db := &SumDB{
+		Server: s,
+		signer: signer,
+		index:  make(map[module.Version]int64, 0),
+	}
+
+	if err := db.load(filename); err != nil {
+		return nil, err
+	}
+
+	db.handler = sumdb.NewServer(db)
+	return db, nil
+}
+
+// Name returns the name of the checksum database, as should be used at GOSUMDB.
+func (db *SumDB) Name() string {
+	return db.signer.Name()
+}
+
+func (db *SumDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	db.handler.ServeHTTP(w, r)
+}
+
+// load reads the log from the file, every line is a base64 encoded record,
+// and keeps the file open to append new records.
+func (db *SumDB) load(filename string) error {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := db.read(f, filename); err != nil {
+		f.Close()
+		return err
+	}
+
+	size, err := os.OpenFile(filename+".size", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	published, err := readSize(size)
+	if err == nil && published > int64(len(db.records)) {
+		err = fmt.Errorf(
+			"the log at %s has %d records, but %d were published, it would be forked",
+			filename, len(db.records), published,
+		)
+	}
+
+	if err != nil {
+		f.Close()
+		size.Close()
+		return err
+	}
+
+	db.file, db.size = f, size
+	return nil
+}
+
+func (db *SumDB) read(f *os.File, filename string) error {
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		record, err := base64.StdEncoding.DecodeString(s.Text())
+		if err != nil {
+			return fmt.Errorf("invalid record %d at %s: %s", len(db.records), filename, err)
+		}
+
+		if err := db.append(record); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+// readSize returns the number of published records, zero if never persisted.
+func readSize(f *os.File) (int64, error) {
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(bytes.TrimSpace(content)) == 0 {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(string(bytes.TrimSpace(content)), 10, 64)
+}
+
+// Close closes the log file.
+func (db *SumDB) Close() error {
+	db.size.Close()
+	return db.file.Close()
+}
+
+// append adds a record to the log in memory, db.mu should be held.
+func (db *SumDB) append(record []byte) error {
+	hashes, err := tlog.StoredHashes(int64(len(db.records)), record, db.hashReader())
+	if err != nil {
+		return err
+	}
+
+	db.add(record, hashes)
+	return nil
+}
+
+// add adds a record, already validated, and its stored hashes to the log in
+// memory, db.mu should be held.
+func (db *SumDB) add(record []byte, hashes []tlog.Hash) {
+	id := int64(len(db.records))
+	for _, line := range bytes.Split(record, []byte("\n")) {
+		f := strings.Fields(string(line))
+		if len(f) == 3 && !strings.HasSuffix(f[1], "/go.mod") {
+			db.index[module.Version{Path: f[0], Version: f[1]}] = id
+		}
+	}
+
+	db.records = append(db.records, record)
+	db.hashes = append(db.hashes, hashes...)
+}
+
+func (db *SumDB) hashReader() tlog.HashReader {
+	return tlog.HashReaderFunc(func(indexes []int64) ([]tlog.Hash, error) {
+		output := make([]tlog.Hash, len(indexes))
+		for i, index := range indexes {
+			if index >= int64(len(db.hashes)) {
+				return nil, fmt.Errorf("unknown hash %d", index)
+			}
+
+			output[i] = db.hashes[index]
+		}
+
+		return output, nil
+	})
+}
+
+// Signed returns the signed tree head of the log, see sumdb.ServerOps.
+func (db *SumDB) Signed(ctx context.Context) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	n := int64(len(db.records))
+	h, err := tlog.TreeHash(n, db.hashReader())
+	if err != nil {
+		return nil, err
+	}
+
+	text := tlog.FormatTree(tlog.Tree{N: n, Hash: h})
+	return note.Sign(&note.Note{Text: string(text)}, db.signer)
+}
+
+// ReadRecords returns n records starting at id, see sumdb.ServerOps.
+func (db *SumDB) ReadRecords(ctx context.Context, id, n int64) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if id < 0 || n < 0 || id+n > int64(len(db.records)) {
+		return nil, os.ErrNotExist
+	}
+
+	return db.records[id : id+n], nil
+}
+
+// ReadTileData returns the data of the given tile, see sumdb.ServerOps.
+func (db *SumDB) ReadTileData(ctx context.Context, t tlog.Tile) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return tlog.ReadTileData(t, db.hashReader())
+}
+
+// Lookup returns the record id of the given module version, if the version is
+// unknown the go.sum lines are computed and added to the log, once for the
+// concurrent lookups of the same version, see sumdb.ServerOps.
+func (db *SumDB) Lookup(ctx context.Context, m module.Version) (int64, error) {
+	db.mu.RLock()
+	id, ok := db.index[m]
+	db.mu.RUnlock()
+
+	if ok {
+		return id, nil
+	}
+
+	key := m.Path + "@" + m.Version
+	for {
+		id, err, shared := db.flight.Do(key, func() (interface{}, error) {
+			return db.lookup(ctx, m)
+		})
+
+		// the lookup that started the shared call was canceled, not this one
+		if shared && isCanceled(err) && ctx.Err() == nil {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return id.(int64), nil
+	}
+}
+
+func (db *SumDB) lookup(ctx context.Context, m module.Version) (int64, error) {
+	record, err := db.buildRecord(ctx, m)
+	if err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if id, ok := db.index[m]; ok {
+		return id, nil
+	}
+
+	// the record is validated before writing it, so the file never holds a
+	// record missing in memory
+	hashes, err := tlog.StoredHashes(int64(len(db.records)), record, db.hashReader())
+	if err != nil {
+		return 0, err
+	}
+
+	if err := db.write(record); err != nil {
+		return 0, err
+	}
+
+	db.add(record, hashes)
+	if err := db.writeSize(); err != nil {
+		fmt.Fprintf(os.Stderr, "error persisting the size of the checksum database log: %s\n", err)
+	}
+
+	return db.index[m], nil
+}
+
+// write appends the record to the log file and syncs it, so a published tree
+// head is never lost. On failure the file is truncated to its previous size,
+// so a partial line isn't read by load.
+func (db *SumDB) write(record []byte) error {
+	fi, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(db.file, base64.StdEncoding.EncodeToString(record))
+	if err == nil {
+		err = db.file.Sync()
+	}
+
+	if err != nil {
+		if terr := db.file.Truncate(fi.Size()); terr != nil {
+			return fmt.Errorf("%s, and the log file can't be restored: %s", err, terr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// writeSize persists the number of records of the log, once they are synced,
+// db.mu should be held.
+func (db *SumDB) writeSize() error {
+	content := []byte(strconv.Itoa(len(db.records)) + "\n")
+	if _, err := db.size.WriteAt(content, 0); err != nil {
+		return err
+	}
+
+	if err := db.size.Truncate(int64(len(content))); err != nil {
+		return err
+	}
+
+	return db.size.Sync()
+}
+
+// buildRecord returns the go.sum lines of the given module version.
+func (db *SumDB) buildRecord(ctx context.Context, m module.Version) ([]byte, error) {
+	pkg, err := db.Server.Package(m.Path)
+	if err != nil || pkg.Name != m.Path {
+		return nil, os.ErrNotExist
+	}
+
+	// the checksum database uses its own credentials, so every call to the
+	// upstream is rate limited
+	if !db.Server.Limiter.AllowUpstream(pkg.Repository.Host) {
+		return nil, ErrRateLimited
+	}
+
+	f := db.Server.newUpstreamFetcher(pkg, db.Auth)
+	versions, err := f.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := strings.TrimSuffix(m.Version, "+incompatible")
+	ref, ok := versions.References[tag]
+	if !ok {
+		ref, ok = versions.References[strings.TrimPrefix(tag, "v")]
+	}
+
+	if !ok || !ref.IsTag() {
+		return nil, os.ErrNotExist
+	}
+
+	if !db.Server.Limiter.AllowUpstream(pkg.Repository.Host) {
+		return nil, ErrRateLimited
+	}
+
+	s, err := f.Snapshot(ctx, versions.Peel(ref).Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	zipHash, gomodHash, err := hashModule(s, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf(
+		"%s %s %s\n%s %s/go.mod %s\n",
+		m.Path, m.Version, zipHash,
+		m.Path, m.Version, gomodHash,
+	)), nil
+}
+
+// hashModule returns the h1: hashes of the module zip and the go.mod file of
+// the given snapshot, following the same rules as the go command.
+func hashModule(s *Snapshot, m module.Version) (zipHash, gomodHash string, err error) {
+	files, err := s.Files()
+	if err != nil {
+		return "", "", err
+	}
+
+	gomod, ok := files["go.mod"]
+	if !ok {
+		gomod = []byte(fmt.Sprintf("module %s\n", m.Path))
+	}
+
+	gomodHash, err = dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(gomod)), nil
+	})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := m.Path + "@" + m.Version + "/"
+	var names []string
+	for name := range files {
+		if isModuleFile(name, files) {
+			names = append(names, prefix+name)
+		}
+	}
+
+	zipHash, err = dirhash.Hash1(names, func(name string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(files[strings.TrimPrefix(name, prefix)])), nil
+	})
+
+	return zipHash, gomodHash, err
+}
+
+// isModuleFile returns true if the file is part of the module zip, the files
+// from nested modules and vendored packages are excluded.
+func isModuleFile(name string, files map[string][]byte) bool {
+	if isVendoredPackage(name) {
+		return false
+	}
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := files[dir+"/go.mod"]; ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isVendoredPackage(name string) bool {
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i += len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		i += j + len("/vendor/")
+	} else {
+		return false
+	}
+
+	return strings.Contains(name[i:], "/")
+}
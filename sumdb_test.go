@@ -0,0 +1,179 @@
+package stable
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+	. "gopkg.in/check.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+type SumDBSuite struct {
+	dir  string
+	skey string
+	vkey string
+}
+
+var _ = Suite(&SumDBSuite{})
+
+func (s *SumDBSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "go-stable-sumdb")
+	c.Assert(err, IsNil)
+
+	s.skey, s.vkey, err = note.GenerateKey(rand.Reader, "foo.bar")
+	c.Assert(err, IsNil)
+}
+
+func (s *SumDBSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *SumDBSuite) TestLoad(c *C) {
+	record := "" +
+		"foo.bar/org/repository.v1 v1.0.0 h1:KpmDwxyd35IbGD0CE5QtRGnAeNbOCNK2iD3EIz2VAFY=\n" +
+		"foo.bar/org/repository.v1 v1.0.0/go.mod h1:FsprAz1bWG1mFrOv8IwGAQS2hwgl2TNRd8fb7AxIUsI=\n"
+
+	filename := filepath.Join(s.dir, "sumdb.log")
+	err := ioutil.WriteFile(filename, []byte(base64.StdEncoding.EncodeToString([]byte(record))+"\n"), 0600)
+	c.Assert(err, IsNil)
+
+	db, err := NewSumDB(NewDefaultServer("foo.bar"), s.skey, filename)
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	c.Assert(db.Name(), Equals, "foo.bar")
+
+	id, err := db.Lookup(context.Background(), module.Version{Path: "foo.bar/org/repository.v1", Version: "v1.0.0"})
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, int64(0))
+
+	records, err := db.ReadRecords(context.Background(), 0, 1)
+	c.Assert(err, IsNil)
+	c.Assert(string(records[0]), Equals, record)
+
+	_, err = db.ReadRecords(context.Background(), 0, 2)
+	c.Assert(err, Equals, os.ErrNotExist)
+
+	signed, err := db.Signed(context.Background())
+	c.Assert(err, IsNil)
+
+	verifier, err := note.NewVerifier(s.vkey)
+	c.Assert(err, IsNil)
+
+	n, err := note.Open(signed, note.VerifierList(verifier))
+	c.Assert(err, IsNil)
+
+	tree, err := tlog.ParseTree([]byte(n.Text))
+	c.Assert(err, IsNil)
+	c.Assert(tree.N, Equals, int64(1))
+	c.Assert(tree.Hash, Equals, tlog.RecordHash([]byte(record)))
+}
+
+func (s *SumDBSuite) TestLoadTruncated(c *C) {
+	record := "foo.bar/org/repository.v1 v1.0.0 h1:KpmDwxyd35IbGD0CE5QtRGnAeNbOCNK2iD3EIz2VAFY=\n"
+
+	filename := filepath.Join(s.dir, "sumdb.log")
+	err := ioutil.WriteFile(filename, []byte(base64.StdEncoding.EncodeToString([]byte(record))+"\n"), 0600)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filename+".size", []byte("1\n"), 0600)
+	c.Assert(err, IsNil)
+
+	db, err := NewSumDB(NewDefaultServer("foo.bar"), s.skey, filename)
+	c.Assert(err, IsNil)
+	db.Close()
+
+	// a record published before was lost, the log would be forked
+	err = ioutil.WriteFile(filename+".size", []byte("2\n"), 0600)
+	c.Assert(err, IsNil)
+
+	_, err = NewSumDB(NewDefaultServer("foo.bar"), s.skey, filename)
+	c.Assert(err, ErrorMatches, ".* has 1 records, but 2 were published.*")
+}
+
+func (s *SumDBSuite) TestLookupRateLimited(c *C) {
+	server := NewDefaultServer("foo.bar")
+	server.Limiter = NewRateLimiter(0, 0, 0.001, 1)
+	server.Limiter.AllowUpstream("github.com")
+
+	db, err := NewSumDB(server, s.skey, filepath.Join(s.dir, "sumdb.log"))
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	_, err = db.Lookup(context.Background(), module.Version{Path: "foo.bar/org/repository.v1", Version: "v1.0.0"})
+	c.Assert(err, Equals, ErrRateLimited)
+	c.Assert(db.records, HasLen, 0)
+}
+
+func (s *SumDBSuite) TestNewSumDBInvalidKey(c *C) {
+	_, err := NewSumDB(NewDefaultServer("foo.bar"), "foo", filepath.Join(s.dir, "sumdb.log"))
+	c.Assert(err, NotNil)
+}
+
+func (s *SumDBSuite) TestIsModuleFile(c *C) {
+	files := map[string][]byte{
+		"go.mod":                   nil,
+		"foo.go":                   nil,
+		"bar/bar.go":               nil,
+		"nested/go.mod":            nil,
+		"nested/qux/qux.go":        nil,
+		"vendor/modules.txt":       nil,
+		"vendor/github.com/a/a.go": nil,
+	}
+
+	c.Assert(isModuleFile("go.mod", files), Equals, true)
+	c.Assert(isModuleFile("foo.go", files), Equals, true)
+	c.Assert(isModuleFile("bar/bar.go", files), Equals, true)
+	c.Assert(isModuleFile("nested/go.mod", files), Equals, false)
+	c.Assert(isModuleFile("nested/qux/qux.go", files), Equals, false)
+	c.Assert(isModuleFile("vendor/modules.txt", files), Equals, true)
+	c.Assert(isModuleFile("vendor/github.com/a/a.go", files), Equals, false)
+}
+
+func (s *SumDBSuite) TestHashModule(c *C) {
+	pkg := &Package{}
+	pkg.Repository, _ = transport.NewEndpoint("https://github.com/pkg/errors")
+
+	f := NewFetcher(pkg, nil)
+	versions, err := f.Versions(context.Background())
+	c.Assert(err, IsNil)
+
+	ref, ok := versions.References["v0.9.1"]
+	c.Assert(ok, Equals, true)
+
+	snapshot, err := f.Snapshot(context.Background(), versions.Peel(ref).Hash())
+	c.Assert(err, IsNil)
+
+	// as in the go.sum computed by go mod download, the repository has no go.mod
+	zipHash, gomodHash, err := hashModule(snapshot, module.Version{Path: "github.com/pkg/errors", Version: "v0.9.1"})
+	c.Assert(err, IsNil)
+	c.Assert(zipHash, Equals, "h1:FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4=")
+	c.Assert(gomodHash, Equals, "h1:bwawxfHBFNV+L2hUp1rHADufV3IMtnDRdf1r5NINEl0=")
+}
+
+func (s *SumDBSuite) TestDoSumDBResponseRateLimited(c *C) {
+	server := NewDefaultServer("foo.bar")
+	server.Limiter = NewRateLimiter(0.001, 1, 0, 0)
+	server.buildRouter()
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/sumdb/latest", nil)
+	r.RemoteAddr = "10.0.0.1:4242"
+
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+
+	w = httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusTooManyRequests)
+}
@@ -0,0 +1,111 @@
+package stable
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/mcuadros/go-version"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// checkMetaImport returns false, writing the response, if the package can't be
+// fetched: the repository or the version doesn't exist, if VerifyImports, or
+// the subpackage doesn't exist, if Subpackages isn't nil. The checks are
+// skipped if the upstream requires auth and no credentials are given, since go
+// get doesn't always send them requesting the meta import. This can't be
+// avoided: the providers, eg.: GitHub, answer the same to the anonymous
+// requests of private and missing repositories, even at its APIs.
+func (s *Server) checkMetaImport(w http.ResponseWriter, r *http.Request, pkg *Package) bool {
+	checkSubpackage := s.Subpackages != nil && pkg.Subpackage != ""
+	if !s.VerifyImports && !checkSubpackage {
+		return true
+	}
+
+	fetcher := s.newFetcher(r, pkg)
+	versions, err := s.getVersions(r, fetcher, pkg)
+	if err == nil {
+		var ref, peeled *plumbing.Reference
+		if ref, peeled, err = s.resolveVersion(pkg, versions); err == nil {
+			return !checkSubpackage || s.checkSubpackage(w, r, fetcher, pkg, ref, peeled)
+		}
+	}
+
+	_, _, hasAuth := r.BasicAuth()
+	switch {
+	case err == transport.ErrAuthorizationRequired && !hasAuth:
+		return true
+	case err == transport.ErrAuthorizationRequired,
+		err == transport.ErrRepositoryNotFound,
+		err == ErrVersionNotFound:
+		s.doPackageNotFound(w, r, pkg, versions)
+	default:
+		s.handleError(w, r, err)
+	}
+
+	return false
+}
+
+// doPackageNotFound writes a 404 page listing the majors available, if the
+// repository exists.
+func (s *Server) doPackageNotFound(w http.ResponseWriter, r *http.Request, pkg *Package, v *Versions) {
+	reason := fmt.Sprintf("repository %s not found", pkg.RepositoryName())
+	majors := ""
+	if v != nil {
+		reason = fmt.Sprintf("no version of %s matches %s", pkg.RepositoryName(), pkg.Constrain)
+		majors = s.buildMajorsHTML(r, v)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, notFoundTemplate,
+		html.EscapeString(pkg.Name), html.EscapeString(reason), majors,
+	)
+}
+
+func (s *Server) buildMajorsHTML(r *http.Request, v *Versions) string {
+	majors := v.Mayor()
+	if len(majors) == 0 {
+		return ""
+	}
+
+	var names []string
+	for name := range majors {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return version.CompareSimple(version.Normalize(names[i]), version.Normalize(names[j])) < 0
+	})
+
+	vars := make(map[string]string, 0)
+	for key, value := range mux.Vars(r) {
+		vars[key] = value
+	}
+
+	output := "\n\t\t\t<h2>Available versions</h2>\n\t\t\t<ul>\n"
+	for _, name := range names {
+		vars[ConstraintKey] = name
+		output += fmt.Sprintf(
+			"\t\t\t\t<li>%s: %s</li>\n",
+			html.EscapeString(s.buildPackageFromVars(vars).Name),
+			html.EscapeString(majors[name].Name().Short()),
+		)
+	}
+
+	return output + "\t\t\t</ul>\n\t\t"
+}
+
+var notFoundTemplate = "" +
+	`<html>
+		<head>
+			<title>%s not found</title>
+		</head>
+		<body>
+			<h1>%[1]s not found</h1>
+			<p>%s</p>%s
+		</body>
+	</html>`
@@ -0,0 +1,75 @@
+package stable
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+type VerifySuite struct{}
+
+var _ = Suite(&VerifySuite{})
+
+func (s *VerifySuite) TestDoMetaImportResponse(c *C) {
+	server := NewDefaultServer("foo.bar")
+	server.VerifyImports = true
+	server.buildRouter()
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/git-fixtures/releases.v1?go-get=1", nil)
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	r, _ = http.NewRequest("GET", "http://foo.bar/git-fixtures/releases.v7/subpackage?go-get=1", nil)
+	w = httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "text/html")
+	c.Assert(w.Body.String(), Matches, `(?s).*<h1>foo.bar/git-fixtures/releases.v7 not found</h1>.*`)
+	c.Assert(w.Body.String(), Matches, `(?s).*<p>no version of github.com/git-fixtures/releases matches v7</p>.*`)
+	c.Assert(w.Body.String(), Matches, `(?s).*<li>foo.bar/git-fixtures/releases.v1: v1[^<]*</li>.*`)
+}
+
+func (s *VerifySuite) TestDoMetaImportResponseNotFound(c *C) {
+	upstream := httptest.NewTLSServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	server := NewDefaultServer("foo.bar")
+	server.Default.Server = upstream.Listener.Addr().String()
+	server.Client = upstream.Client()
+	server.VerifyImports = true
+	server.buildRouter()
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/org/missing.v1?go-get=1", nil)
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+	c.Assert(w.Body.String(), Matches, `(?s).*<p>repository `+upstream.Listener.Addr().String()+`/org/missing not found</p>.*`)
+}
+
+// TestDoMetaImportResponseUnauthorized checks that the anonymous requests to
+// upstreams requiring auth are answered, since a private repository can't be
+// told apart from a missing one, eg.: GitHub answers 401 to both.
+func (s *VerifySuite) TestDoMetaImportResponseUnauthorized(c *C) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	server := NewDefaultServer("foo.bar")
+	server.Default.Server = upstream.Listener.Addr().String()
+	server.Client = upstream.Client()
+	server.VerifyImports = true
+	server.buildRouter()
+
+	r, _ := http.NewRequest("GET", "http://foo.bar/org/private.v1?go-get=1", nil)
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	r.SetBasicAuth("token", "")
+	w = httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+}